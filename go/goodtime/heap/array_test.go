@@ -0,0 +1,950 @@
+package heap
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Allocate a bare mono as an element for arrays.
+func allocateMono(tb testing.TB, heap *Heap, kind byte) *Mono {
+	wrapped, err := heap.allocator.Allocate(kind, func(mono *Mono) *interface{} {
+		var wrapped interface{}
+		wrapped = mono
+		return &wrapped
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return (*wrapped).(*Mono)
+}
+
+// Sum lengths of all chunks by following the next pointers.
+func sumChunkLengths(tb testing.TB, wa *WrappedArray) uint32 {
+	sum := uint32(0)
+	for chunk := wa.defaultChunk; chunk != nil; {
+		length, err := chunk.ReadLength()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		sum += uint32(length)
+		next, err := chunk.FetchNext()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		chunk = next
+	}
+	return sum
+}
+
+const (
+	fuzzOpAppend = iota
+	fuzzOpIndex
+	fuzzOpSet
+	fuzzOpPop
+	fuzzOpCount
+)
+
+func FuzzArrayOps(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 1, 1})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 9, 1, 7, 1, 8})
+	f.Add([]byte{0, 2, 4, 6, 8, 10, 12, 14, 16, 18, 20, 22, 24, 26, 28, 30, 32, 34, 1, 33})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		// Small regions, so the array and its elements cross regions.
+		heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 64})
+		wa, err := heap.allocator.Array()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// What the array should contain.
+		expected := []address{}
+		for i, op := range ops {
+			switch op % fuzzOpCount {
+			case fuzzOpAppend:
+				element := allocateMono(t, heap, MONO_INT32)
+				if err := wa.Append(element); err != nil {
+					t.Fatalf("#%d Append: %v", i, err)
+				}
+				expected = append(expected, element.beginFrom)
+			case fuzzOpIndex:
+				idx := uint32(op) / fuzzOpCount
+				mono, err := wa.Index(idx)
+				if idx >= uint32(len(expected)) {
+					if err == nil {
+						t.Fatalf("#%d Index(%d) out of range %d but no error", i, idx, len(expected))
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("#%d Index(%d): %v", i, idx, err)
+				}
+				if mono.beginFrom != expected[idx] {
+					t.Fatalf("#%d Index(%d) = %d, expect %d", i, idx, mono.beginFrom, expected[idx])
+				}
+			case fuzzOpSet:
+				idx := uint32(op) / fuzzOpCount
+				element := allocateMono(t, heap, MONO_FLOAT64)
+				err := wa.Set(idx, element)
+				if idx >= uint32(len(expected)) {
+					if err == nil {
+						t.Fatalf("#%d Set(%d) out of range %d but no error", i, idx, len(expected))
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("#%d Set(%d): %v", i, idx, err)
+				}
+				expected[idx] = element.beginFrom
+			case fuzzOpPop:
+				popped, err := wa.Pop()
+				if err != nil {
+					t.Fatalf("#%d Pop: %v", i, err)
+				}
+				if len(expected) == 0 {
+					if popped != nil {
+						t.Fatalf("#%d Pop on empty array returns %d", i, popped.beginFrom)
+					}
+					continue
+				}
+				if popped.beginFrom != expected[len(expected)-1] {
+					t.Fatalf("#%d Pop = %d, expect %d", i, popped.beginFrom, expected[len(expected)-1])
+				}
+				expected = expected[:len(expected)-1]
+			}
+
+			length, err := wa.ReadLength()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if length != uint32(len(expected)) {
+				t.Fatalf("#%d length %d, expect %d", i, length, len(expected))
+			}
+			if sum := sumChunkLengths(t, wa); sum != length {
+				t.Fatalf("#%d chunk lengths sum to %d, expect %d", i, sum, length)
+			}
+		}
+
+		if err := heap.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		for idx, expectedAddress := range expected {
+			mono, err := wa.Index(uint32(idx))
+			if err != nil {
+				t.Fatalf("Index(%d): %v", idx, err)
+			}
+			if mono.beginFrom != expectedAddress {
+				t.Fatalf("Index(%d) = %d, expect %d", idx, mono.beginFrom, expectedAddress)
+			}
+		}
+	})
+}
+
+func TestChunkLayout(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	chunk, err := heap.allocator.Chunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	element := allocateTestInt32(t, heap, 42)
+	if err := chunk.Append(element); err != nil {
+		t.Fatal(err)
+	}
+
+	// [ #0 ] length, [ #1 - #4 ] the first element, right after the header.
+	atLength := chunk.mono.beginOffset + MONO_HEADER_SIZE
+	atSlot := chunk.OffsetFromIndex(0)
+	if atSlot != atLength+1 {
+		t.Errorf("First slot is at %d, expect %d", atSlot, atLength+1)
+	}
+	if length := chunk.mono.region.content[atLength]; length != 1 {
+		t.Errorf("Length byte at %d reads %d, expect %d", atLength, length, 1)
+	}
+	pointer, err := chunk.mono.region.ReadAddress(atSlot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pointer != element.beginFrom {
+		t.Errorf("First slot reads %d, expect %d", pointer, element.beginFrom)
+	}
+	// The last slot ends where the next pointer begins.
+	if end := chunk.OffsetFromIndex(MONO_CHUNK_SIZE-1) + 4; end != chunk.atToNext {
+		t.Errorf("Last slot ends at %d, expect %d", end, chunk.atToNext)
+	}
+}
+
+func TestChunkNextAcrossRegions(t *testing.T) {
+	// An array and a chunk don't fit into one region, so the chunk is in the next one.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 64, NumberRegions: 8})
+	wa := allocateTestArray(t, heap)
+	elements := []*Mono{}
+	for i := int32(0); i < MONO_CHUNK_SIZE+1; i++ {
+		element := allocateTestInt32(t, heap, i)
+		if err := wa.Append(element); err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element)
+	}
+	next, err := wa.defaultChunk.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil || next.mono.region.beginFrom == wa.mono.region.beginFrom {
+		t.Fatalf("Second chunk is not in another region")
+	}
+
+	// The next pointer takes the last 4 bytes of the default chunk, which is the end of the array.
+	at := wa.mono.endOffset - 4
+	if wa.defaultChunk.atToNext != at {
+		t.Errorf("Next pointer is at %d, expect %d", wa.defaultChunk.atToNext, at)
+	}
+	content := wa.mono.region.content
+	if pointer := address(binary.LittleEndian.Uint32(content[at:wa.mono.endOffset])); pointer != next.mono.beginFrom {
+		t.Errorf("Next pointer bytes read %d, expect %d", pointer, next.mono.beginFrom)
+	}
+	for idx, element := range elements {
+		indexed, err := wa.Index(uint32(idx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !indexed.Equals(element) {
+			t.Errorf("Index(%d) = %d, expect %d", idx, indexed.beginFrom, element.beginFrom)
+		}
+	}
+}
+
+func TestChunkRemove(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	chunk, err := heap.allocator.Chunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements := []*Mono{}
+	for i := int32(0); i < MONO_CHUNK_SIZE; i++ {
+		element := allocateTestInt32(t, heap, i)
+		if err := chunk.Append(element); err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element)
+	}
+
+	if err := chunk.Remove(3); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(elements[:3:3], elements[4:]...)
+	length, err := chunk.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(length) != len(expected) {
+		t.Fatalf("Chunk length reads %d, expect %d", length, len(expected))
+	}
+	for i, element := range expected {
+		mono, err := chunk.Index(uint8(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.beginFrom != element.beginFrom {
+			t.Errorf("Index(%d) reads %d, expect %d", i, mono.beginFrom, element.beginFrom)
+		}
+	}
+	last, err := chunk.mono.region.ReadAddress(chunk.OffsetFromIndex(MONO_CHUNK_SIZE - 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 0 {
+		t.Errorf("Vacated slot reads %d, expect %d", last, 0)
+	}
+	if err := chunk.Remove(7); err == nil {
+		t.Errorf("Expect error when removing out of the chunk length")
+	}
+}
+
+func TestArrayLayout(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin := wa.mono.beginOffset
+	if size := wa.mono.endOffset - begin; size != 45 {
+		t.Errorf("Array mono is %d bytes, expect %d", size, 45)
+	}
+	chunk := wa.defaultChunk
+	for _, c := range []struct {
+		name     string
+		at       offset
+		expected offset
+	}{
+		{"array length", wa.atLength, begin + 2},
+		{"default chunk", chunk.mono.beginOffset, begin + 6},
+		{"default chunk length", chunk.atLength, begin + 8},
+		{"first slot", chunk.OffsetFromIndex(0), begin + 9},
+		{"next pointer", chunk.atToNext, begin + 41},
+	} {
+		if c.at != c.expected {
+			t.Errorf("The %s is at %d, expect %d", c.name, c.at, c.expected)
+		}
+	}
+	if length := wa.mono.region.content[begin+8]; length != 0 {
+		t.Errorf("Default chunk length reads %d, expect %d", length, 0)
+	}
+}
+
+func TestNewWrappedArrayAtRegionEnd(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	region, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header fits, but the default chunk goes over the region end.
+	mono, err := region.NewMono(MONO_ARRAY_S8, region.size-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWrappedArray(mono); err == nil {
+		t.Errorf("Expect error when the array is over the region end")
+	}
+}
+
+func TestAllocatorArrayFromSlice(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, n := range []int{0, 8, 20} {
+		elements := []*Mono{}
+		for i := 0; i < n; i++ {
+			elements = append(elements, allocateTestInt32(t, heap, int32(i)))
+		}
+		wa, err := heap.allocator.ArrayFromSlice(elements)
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, err := wa.ReadLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length != uint32(n) {
+			t.Errorf("Array length reads %d, expect %d", length, n)
+		}
+		if sum := sumChunkLengths(t, wa); sum != uint32(n) {
+			t.Errorf("Chunk lengths sum to %d, expect %d", sum, n)
+		}
+		for i, expected := range elements {
+			mono, err := wa.Index(uint32(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mono.beginFrom != expected.beginFrom {
+				t.Errorf("Index(%d) reads %d, expect %d", i, mono.beginFrom, expected.beginFrom)
+			}
+		}
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid: %v", err)
+	}
+}
+
+func TestArraySet(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements := []*Mono{}
+	for i := 0; i < 10; i++ {
+		element := allocateMono(t, heap, MONO_INT32)
+		if err := wa.Append(element); err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element)
+	}
+
+	// #3 is in the default chunk, while #9 is in the next chunk.
+	for _, idx := range []uint32{3, 9} {
+		element := allocateMono(t, heap, MONO_FLOAT64)
+		if err := wa.Set(idx, element); err != nil {
+			t.Fatal(err)
+		}
+		elements[idx] = element
+	}
+
+	length, err := wa.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 10 {
+		t.Errorf("Array length %d, expect %d", length, 10)
+	}
+	for idx, element := range elements {
+		mono, err := wa.Index(uint32(idx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.beginFrom != element.beginFrom || mono.kind != element.kind {
+			t.Errorf("Index(%d) = %d (kind %d), expect %d (kind %d)",
+				idx, mono.beginFrom, mono.kind, element.beginFrom, element.kind)
+		}
+	}
+
+	if err := wa.Set(10, elements[0]); err == nil {
+		t.Errorf("Expect error when setting out of range")
+	}
+}
+
+func TestArrayPop(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	popped, err := wa.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if popped != nil {
+		t.Errorf("Pop on empty array returns %d, expect nil", popped.beginFrom)
+	}
+
+	elements := []*Mono{}
+	for i := 0; i < 9; i++ {
+		element := allocateMono(t, heap, MONO_INT32)
+		if err := wa.Append(element); err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element)
+	}
+
+	// From 9 to 8: the only element of chunk #1 is popped.
+	popped, err = wa.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if popped.beginFrom != elements[8].beginFrom {
+		t.Errorf("Pop = %d, expect %d", popped.beginFrom, elements[8].beginFrom)
+	}
+	length, err := wa.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 8 {
+		t.Errorf("Array length %d, expect %d", length, 8)
+	}
+	next, err := wa.defaultChunk.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil {
+		t.Fatal("Expect chunk #1 still linked")
+	}
+	nextLength, err := next.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextLength != 0 {
+		t.Errorf("Chunk #1 length %d, expect %d", nextLength, 0)
+	}
+	slot, err := next.mono.region.ReadAddress(next.OffsetFromIndex(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot != 0 {
+		t.Errorf("Vacated slot still points to %d", slot)
+	}
+
+	// Append again re-uses the empty chunk #1.
+	element := allocateMono(t, heap, MONO_INT32)
+	if err := wa.Append(element); err != nil {
+		t.Fatal(err)
+	}
+	nextAgain, err := wa.defaultChunk.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextAgain.mono.beginFrom != next.mono.beginFrom {
+		t.Errorf("Append links a new chunk %d, expect re-using %d", nextAgain.mono.beginFrom, next.mono.beginFrom)
+	}
+	indexed, err := wa.Index(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed.beginFrom != element.beginFrom {
+		t.Errorf("Index(8) = %d, expect %d", indexed.beginFrom, element.beginFrom)
+	}
+}
+
+func TestArraySlice(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements := []*Mono{}
+	for i := 0; i < 16; i++ {
+		element := allocateMono(t, heap, MONO_INT32)
+		if err := wa.Append(element); err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element)
+	}
+
+	assertSlice := func(start, end uint32, expected []*Mono) {
+		sliced, err := wa.Slice(start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, err := sliced.ReadLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length != uint32(len(expected)) {
+			t.Fatalf("Slice(%d, %d) length %d, expect %d", start, end, length, len(expected))
+		}
+		for idx, element := range expected {
+			mono, err := sliced.Index(uint32(idx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mono.beginFrom != element.beginFrom {
+				t.Errorf("Slice(%d, %d)[%d] = %d, expect %d", start, end, idx, mono.beginFrom, element.beginFrom)
+			}
+		}
+	}
+
+	// Across the boundary of chunk #0 and #1.
+	assertSlice(6, 12, elements[6:12])
+	assertSlice(0, 16, elements)
+	assertSlice(10, 100, elements[10:])
+	assertSlice(12, 6, []*Mono{})
+
+	// The source array is unchanged.
+	length, err := wa.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 16 {
+		t.Errorf("Source array length %d, expect %d", length, 16)
+	}
+}
+
+func TestArrayConcat(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	elements := []*Mono{}
+	for i := 0; i < 13; i++ {
+		elements = append(elements, allocateMono(t, heap, MONO_INT32))
+	}
+	foo := allocateTestArray(t, heap, elements[:3]...)
+	bar := allocateTestArray(t, heap, elements[3:]...)
+
+	joined, err := foo.Concat(bar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, err := joined.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 13 {
+		t.Fatalf("Joined length %d, expect %d", length, 13)
+	}
+	for idx, element := range elements {
+		mono, err := joined.Index(uint32(idx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.beginFrom != element.beginFrom {
+			t.Errorf("Joined[%d] = %d, expect %d", idx, mono.beginFrom, element.beginFrom)
+		}
+	}
+
+	// Sources are unchanged.
+	for _, source := range []struct {
+		wa     *WrappedArray
+		length uint32
+	}{{foo, 3}, {bar, 10}} {
+		length, err := source.wa.ReadLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length != source.length {
+			t.Errorf("Source length %d, expect %d", length, source.length)
+		}
+	}
+}
+
+func TestArrayTraverseChunks(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	countChunks := func(wa *WrappedArray) int {
+		count := 0
+		err := wa.traverseChunks(func(chunk *WrappedChunk) error {
+			count += 1
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	wa := allocateTestArray(t, heap)
+	if count := countChunks(wa); count != 0 {
+		t.Errorf("Empty array visits %d chunks, expect %d", count, 0)
+	}
+	for i := 0; i < 20; i++ {
+		if err := wa.Append(allocateMono(t, heap, MONO_INT32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := countChunks(wa); count != 3 {
+		t.Errorf("20 elements array visits %d chunks, expect %d", count, 3)
+	}
+}
+
+func TestArrayLastChunk(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa := allocateTestArray(t, heap)
+	last, err := wa.lastChunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.mono.beginFrom != wa.defaultChunk.mono.beginFrom {
+		t.Errorf("Last chunk of empty array at %d, expect the default chunk at %d",
+			last.mono.beginFrom, wa.defaultChunk.mono.beginFrom)
+	}
+
+	for i := 0; i < 9; i++ {
+		if err := wa.Append(allocateMono(t, heap, MONO_INT32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, err = wa.lastChunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := wa.defaultChunk.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.mono.beginFrom != next.mono.beginFrom {
+		t.Errorf("Last chunk of 9 elements array at %d, expect chunk #1 at %d",
+			last.mono.beginFrom, next.mono.beginFrom)
+	}
+	length, err := last.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 1 {
+		t.Errorf("Last chunk length %d, expect %d", length, 1)
+	}
+}
+
+func TestArrayToSlice(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa := allocateTestArray(t, heap)
+	for i := 0; i < 25; i++ {
+		if err := wa.Append(allocateMono(t, heap, MONO_INT32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	monos, err := wa.ToSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monos) != 25 {
+		t.Fatalf("ToSlice returns %d monos, expect %d", len(monos), 25)
+	}
+	for idx, mono := range monos {
+		indexed, err := wa.Index(uint32(idx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.beginFrom != indexed.beginFrom {
+			t.Errorf("ToSlice()[%d] = %d, expect %d", idx, mono.beginFrom, indexed.beginFrom)
+		}
+	}
+}
+
+func TestArrayForEach(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa := allocateTestArray(t, heap)
+	for i := int32(1); i <= 12; i++ {
+		if err := wa.Append(allocateTestInt32(t, heap, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum := int32(0)
+	indexes := uint32(0)
+	err := wa.ForEach(func(idx uint32, element *Mono) error {
+		i, err := element.region.ReadInt32(element.valueFromOffset)
+		sum += i
+		indexes += idx
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 78 || indexes != 66 {
+		t.Errorf("ForEach sums elements to %d and indexes to %d, expect %d and %d", sum, indexes, 78, 66)
+	}
+
+	// Stop at the first error.
+	stop := errors.New("stop")
+	called := 0
+	err = wa.ForEach(func(idx uint32, element *Mono) error {
+		called++
+		if idx == 9 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || called != 10 {
+		t.Errorf("ForEach returns %v after %d calls, expect %v after %d", err, called, stop, 10)
+	}
+}
+
+func TestArrayMap(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa := allocateTestArray(t, heap)
+	for i := int32(0); i < 12; i++ {
+		if err := wa.Append(allocateTestInt32(t, heap, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doubled, err := wa.Map(func(element *Mono) (*Mono, error) {
+		i, err := element.region.ReadInt32(element.valueFromOffset)
+		if err != nil {
+			return nil, err
+		}
+		return allocateTestInt32(t, heap, i*2), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, err := doubled.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 12 {
+		t.Fatalf("Mapped array has %d elements, expect %d", length, 12)
+	}
+	for idx := uint32(0); idx < 12; idx++ {
+		for _, expected := range []struct {
+			array *WrappedArray
+			i     int32
+		}{{wa, int32(idx)}, {doubled, int32(idx) * 2}} {
+			element, err := expected.array.Index(idx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i, err := element.region.ReadInt32(element.valueFromOffset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i != expected.i {
+				t.Errorf("Element #%d of the array at %d is %d, expect %d", idx, expected.array.mono.beginFrom, i, expected.i)
+			}
+		}
+	}
+}
+
+func TestArrayFilter(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wa := allocateTestArray(t, heap)
+	for i := int32(0); i < 10; i++ {
+		if err := wa.Append(allocateTestInt32(t, heap, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	even, err := wa.Filter(func(element *Mono) (bool, error) {
+		i, err := element.region.ReadInt32(element.valueFromOffset)
+		return i%2 == 0, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements, err := even.ToSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 5 {
+		t.Fatalf("Filtered array has %d elements, expect %d", len(elements), 5)
+	}
+	for idx, element := range elements {
+		i, err := element.region.ReadInt32(element.valueFromOffset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i != int32(idx)*2 {
+			t.Errorf("Element #%d of the filtered array is %d, expect %d", idx, i, idx*2)
+		}
+	}
+	length, err := wa.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 10 {
+		t.Errorf("Source array has %d elements after Filter, expect %d", length, 10)
+	}
+}
+
+func TestArrayIndexOf(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	elements := []*Mono{}
+	for i := 0; i < 14; i++ {
+		elements = append(elements, allocateMono(t, heap, MONO_INT32))
+	}
+	wa := allocateTestArray(t, heap, elements...)
+	// The same element again after #11; the first index is expected.
+	if err := wa.Append(elements[11]); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := wa.IndexOf(elements[11])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != 11 {
+		t.Errorf("IndexOf = %d, expect %d", idx, 11)
+	}
+
+	missing := allocateMono(t, heap, MONO_INT32)
+	idx, err = wa.IndexOf(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != -1 {
+		t.Errorf("IndexOf missing = %d, expect %d", idx, -1)
+	}
+}
+
+func BenchmarkAllocatorArray(b *testing.B) {
+	// Enough regions for all arrays, so the heap never grows.
+	size, err := monoSizeFromKind(MONO_ARRAY_S8)
+	if err != nil {
+		b.Fatal(err)
+	}
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: b.N/int((4096-5)/size) + 1})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := heap.allocator.Array(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestArrayDelete(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 chunks: 8 + 8 + 1 elements.
+	elements := []*Mono{}
+	for i := int32(0); i < 17; i++ {
+		element := allocateTestInt32(t, heap, i)
+		if err := wa.Append(element); err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element)
+	}
+
+	if err := wa.Delete(5); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(elements[:5:5], elements[6:]...)
+	length, err := wa.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(length) != len(expected) {
+		t.Fatalf("Array length reads %d, expect %d", length, len(expected))
+	}
+	if sum := sumChunkLengths(t, wa); sum != length {
+		t.Errorf("Chunk lengths sum to %d, expect %d", sum, length)
+	}
+	for i, element := range expected {
+		mono, err := wa.Index(uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.beginFrom != element.beginFrom {
+			t.Errorf("Index(%d) reads %d, expect %d", i, mono.beginFrom, element.beginFrom)
+		}
+	}
+
+	// The third chunk is empty now, so it is unlinked.
+	second, err := wa.defaultChunk.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := second.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third != nil {
+		t.Errorf("Empty trailing chunk is still linked at %d", third.mono.beginFrom)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after delete: %v", err)
+	}
+	if err := wa.Delete(16); err == nil {
+		t.Errorf("Expect error when deleting out of the array length")
+	}
+}
+
+func TestArrayInsert(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A full default chunk, so the first insert pushes an element to a new chunk.
+	expected := []*Mono{}
+	for i := int32(0); i < MONO_CHUNK_SIZE; i++ {
+		element := allocateTestInt32(t, heap, i)
+		if err := wa.Append(element); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, element)
+	}
+
+	for _, idx := range []uint32{0, 4, MONO_CHUNK_SIZE + 2} {
+		element := allocateTestInt32(t, heap, 100+int32(idx))
+		if err := wa.Insert(idx, element); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected[:idx:idx], append([]*Mono{element}, expected[idx:]...)...)
+	}
+
+	length, err := wa.ReadLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(length) != len(expected) {
+		t.Fatalf("Array length reads %d, expect %d", length, len(expected))
+	}
+	if sum := sumChunkLengths(t, wa); sum != length {
+		t.Errorf("Chunk lengths sum to %d, expect %d", sum, length)
+	}
+	for i, element := range expected {
+		mono, err := wa.Index(uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.beginFrom != element.beginFrom {
+			t.Errorf("Index(%d) reads %d, expect %d", i, mono.beginFrom, element.beginFrom)
+		}
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after insert: %v", err)
+	}
+	if err := wa.Insert(length+1, expected[0]); err == nil {
+		t.Errorf("Expect error when inserting after the array length")
+	}
+}
@@ -0,0 +1,122 @@
+package heap
+
+import (
+	"errors"
+)
+
+// Automatic GC, for hosts which would rather not call MinorGC themselves.
+//
+// Once bytes used in regions exceed a fraction of the capacity, Allocator.Allocate
+// runs a minor GC before allocating. If that doesn't bring the used bytes under
+// the threshold, like when most garbage is tenured, it escalates to Compact.
+// There is no other full GC: Compact collects all regions, tenured ones too,
+// and its GCStats have Full set. An allocation failing with
+// ErrHeapFull runs them as well, and is tried once more. If the heap is still full,
+// it fails with ErrHeapFull.
+//
+// A GC needs the roots, and updates them, so the host registers them by Heap.SetRoots.
+// Without roots there is no automatic GC, since every mono would be dropped.
+//
+// Only Allocate, AllocateWithSite and Allocator.Reserve run a GC. Other allocations, like Allocator.Chunk
+// in the middle of appending to an array, hold wrappers a GC would leave stale.
+// The host calling Allocate must not hold monos other than the roots across the call
+// either, and fetch them again from the roots after it.
+
+// Run a GC before Allocate once used bytes are over the fraction of the capacity,
+// like 0.75. 0 turns automatic GC off, which is the default.
+// The capacity is the content blocks the heap has now, not after growing.
+func (heap *Heap) SetGCThreshold(fraction float64) {
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+	heap.gcThreshold = fraction
+	heap.gcFloor = 0
+}
+
+// Register the roots automatic GC starts from, like the interpreter stack.
+// The callback is called for each automatic GC, with the heap lock held, so it must
+// not allocate. The slice it returns is updated in place, like the one passed to
+// MinorGC, so it must be the host's own slice, not a copy.
+func (heap *Heap) SetRoots(roots func() []address) {
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+	heap.roots = roots
+}
+
+// Bytes used in regions in use, with their counter and kind bytes,
+// and the bytes of all content blocks.
+func (heap *Heap) usedBytesLocked() (used uint64, capacity uint64) {
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		region := heap.RegionFromContent(i*uint64(heap.regionSize), heap.regionSize, heap.content[i])
+		used += uint64(region.counter)
+	}
+	return used, uint64(len(heap.content)) * uint64(heap.regionSize)
+}
+
+// If the used bytes are over the threshold. After a GC cannot bring them under it,
+// there is no GC again until another region is used, instead of collecting before
+// every allocation for nothing.
+func (heap *Heap) overGCThresholdLocked() bool {
+	if heap.gcThreshold <= 0 || heap.roots == nil {
+		return false
+	}
+	used, capacity := heap.usedBytesLocked()
+	return float64(used) > heap.gcThreshold*float64(capacity) && used > heap.gcFloor
+}
+
+// Minor GC, and compaction if it is not enough. Return the stats of each GC run,
+// for the GC callback to be called after the lock is released.
+func (heap *Heap) autoGCLocked() ([]GCStats, error) {
+	roots := heap.roots()
+	stats, err := heap.minorGCLocked(roots)
+	if err != nil {
+		return nil, err
+	}
+	collected := []GCStats{stats}
+	if heap.overGCThresholdLocked() {
+		stats, err := heap.compactLocked(roots)
+		if err != nil {
+			return collected, err
+		}
+		collected = append(collected, stats)
+	}
+	heap.gcFloor = 0
+	if heap.overGCThresholdLocked() {
+		used, _ := heap.usedBytesLocked()
+		heap.gcFloor = used + uint64(heap.regionSize)
+	}
+	return collected, nil
+}
+
+// Allocate with automatic GC. See SetGCThreshold.
+func (a *Allocator) allocateMonoCollecting(kind byte, site uint32) (*Mono, error) {
+	heap := a.heap
+	heap.mu.Lock()
+	collected := []GCStats{}
+	var mono *Mono
+	var err error
+	if heap.overGCThresholdLocked() {
+		var stats []GCStats
+		if stats, err = heap.autoGCLocked(); err == nil {
+			collected = append(collected, stats...)
+		}
+	}
+	if err == nil {
+		mono, err = a.allocateMonoLocked(kind, site)
+	}
+	if errors.Is(err, ErrHeapFull) && heap.gcThreshold > 0 && heap.roots != nil {
+		var stats []GCStats
+		if stats, err = heap.autoGCLocked(); err == nil {
+			collected = append(collected, stats...)
+			mono, err = a.allocateMonoLocked(kind, site)
+		}
+	}
+	heap.mu.Unlock()
+
+	// Out of the lock, so the callback can allocate.
+	if heap.onGC != nil {
+		for _, stats := range collected {
+			heap.onGC(stats)
+		}
+	}
+	return mono, err
+}
@@ -0,0 +1,116 @@
+package heap
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAutoGC(t *testing.T) {
+	// Room for about 80 int32 monos without GC.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 64, NumberRegions: 8})
+	roots := []address{allocateTestInt32(t, heap, 42).beginFrom}
+	heap.SetRoots(func() []address {
+		return roots
+	})
+	heap.SetGCThreshold(0.5)
+	collected := 0
+	heap.OnGC(func(stats GCStats) {
+		collected++
+	})
+
+	for i := 0; i < 1000; i++ {
+		allocateMono(t, heap, MONO_INT32)
+	}
+	if collected == 0 {
+		t.Errorf("No GC runs while allocating over the capacity")
+	}
+	kept, err := heap.FetchMono(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := kept.region.ReadInt32(kept.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 42 {
+		t.Errorf("Root after GC is %d, expect %d", i, 42)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after GC: %v", err)
+	}
+}
+
+func TestAutoGCEscalates(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 256, NumberRegions: 16, TenureThreshold: 1})
+	roots := []address{}
+	for i := int32(0); i < 200; i++ {
+		roots = append(roots, allocateTestInt32(t, heap, i).beginFrom)
+	}
+	// Minor GCs tenure the monos.
+	for i := 0; i < 2; i++ {
+		if _, err := heap.MinorGC(roots); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// All but one are tenured garbage now, which a minor GC doesn't collect.
+	roots = roots[:1]
+	heap.SetRoots(func() []address {
+		return roots
+	})
+	heap.SetGCThreshold(0.25)
+	collected := []GCStats{}
+	heap.OnGC(func(stats GCStats) {
+		collected = append(collected, stats)
+	})
+
+	allocateMono(t, heap, MONO_INT32)
+	if len(collected) != 2 || collected[0].Full || !collected[1].Full {
+		t.Fatalf("Automatic GC runs %+v, expect a minor GC then Compact", collected)
+	}
+	if collected[0].BytesReclaimed != 0 {
+		t.Errorf("Minor GC reclaims %d bytes, expect %d", collected[0].BytesReclaimed, 0)
+	}
+	if collected[1].BytesReclaimed != 199*6 {
+		t.Errorf("Compact reclaims %d bytes, expect %d", collected[1].BytesReclaimed, 199*6)
+	}
+	if heap.overGCThresholdLocked() {
+		used, capacity := heap.usedBytesLocked()
+		t.Errorf("Heap uses %d bytes of %d after GC, expect under the threshold", used, capacity)
+	}
+	kept, err := heap.FetchMono(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, err := kept.region.ReadInt32(kept.valueFromOffset); err != nil || i != 0 {
+		t.Errorf("Root after GC is %d, expect %d: %v", i, 0, err)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after GC: %v", err)
+	}
+}
+
+func TestAutoGCHeapFull(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 64, NumberRegions: 8})
+	// Everything allocated is a root, so GC cannot free anything.
+	roots := []address{}
+	heap.SetRoots(func() []address {
+		return roots
+	})
+	heap.SetGCThreshold(0.5)
+
+	for i := 0; i < 1000; i++ {
+		wrapped, err := heap.allocator.Allocate(MONO_INT32, func(mono *Mono) *interface{} {
+			var wrapped interface{}
+			wrapped = mono
+			return &wrapped
+		})
+		if errors.Is(err, ErrHeapFull) {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, (*wrapped).(*Mono).beginFrom)
+	}
+	t.Errorf("Allocating %d live monos doesn't fail with ErrHeapFull", 1000)
+}
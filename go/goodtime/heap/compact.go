@@ -0,0 +1,239 @@
+package heap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Compaction slides live monos toward the beginning of their own region,
+// so the bytes of dead monos between them are free again. Monos are not
+// copied to other regions, and their region kinds and ages don't change,
+// so it is lighter than a GC copying monos between generations.
+//
+// Live monos are the ones reachable from the roots, in all regions,
+// not only young ones. Monos not reachable are dropped. So it is the full GC
+// of the heap, which automatic GC escalates to after a minor GC.
+
+// Compact all regions in use. `roots` are updated in place to where the monos
+// are moved, like MinorGC does. Other monos the caller holds, and their
+// wrappers, are stale after compaction, so fetch them again from the roots.
+//
+// The stats are passed to the callback of OnGC too, with Full set.
+func (heap *Heap) Compact(roots []address) (GCStats, error) {
+	heap.mu.Lock()
+	stats, err := heap.compactLocked(roots)
+	heap.mu.Unlock()
+	if err != nil {
+		return stats, err
+	}
+	// Out of the lock, so the callback can allocate.
+	if heap.onGC != nil {
+		heap.onGC(stats)
+	}
+	return stats, nil
+}
+
+func (heap *Heap) compactLocked(roots []address) (GCStats, error) {
+	begin := time.Now()
+	stats := GCStats{Full: true}
+	live, err := heap.Reachable(roots)
+	if err != nil {
+		return stats, err
+	}
+
+	// From the old address of each live mono to its new address,
+	// even if it stays where it is.
+	forwards := map[address]address{}
+	moved := []*Mono{}
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		region := heap.RegionFromContent(i*uint64(heap.regionSize), heap.regionSize, heap.content[i])
+		monos, err := region.Monos()
+		if err != nil {
+			return stats, err
+		}
+		used := region.counter
+		liveOffsets := []offset{}
+		for _, mono := range monos {
+			if live[mono.beginFrom] {
+				liveOffsets = append(liveOffsets, mono.beginOffset)
+			}
+		}
+		offsets, err := region.Defragment(liveOffsets)
+		if err != nil {
+			return stats, err
+		}
+		stats.BytesReclaimed += uint64(used - region.counter)
+		for _, from := range liveOffsets {
+			to := offsets[from]
+			if to != from {
+				stats.MonosCopied++
+			}
+			compacted, err := region.NewMono(region.content[to], to)
+			if err != nil {
+				return stats, err
+			}
+			forwards[region.beginFrom+uint64(from)] = compacted.beginFrom
+			moved = append(moved, compacted)
+		}
+	}
+
+	// Slots in old regions are remembered again when they are rewritten.
+	heap.remembered = nil
+	for _, mono := range moved {
+		slots, err := mono.pointerSlots()
+		if err != nil {
+			return stats, err
+		}
+		stats.MonosScanned++
+		for _, at := range slots {
+			pointer, err := mono.region.ReadAddress(at)
+			if err != nil {
+				return stats, err
+			}
+			if pointer == 0 || heap.isTaggedInt(pointer) {
+				continue
+			}
+			if err := mono.region.writeAddressBarrierLocked(at, forwards[pointer]); err != nil {
+				return stats, err
+			}
+		}
+	}
+	for i, root := range roots {
+		if root != 0 && !heap.isTaggedInt(root) {
+			roots[i] = forwards[root]
+		}
+	}
+	heap.compactSideTables(forwards)
+
+	// Freed bytes are after the counters now.
+	heap.allocator.holes = nil
+	if err := heap.readCountersLocked(); err != nil {
+		return stats, err
+	}
+	stats.Duration = time.Since(begin)
+	return stats, nil
+}
+
+// Slide the live monos at the offsets toward the beginning of the region, in the order
+// they are in the region, so no gaps are left between them. Other monos are dropped,
+// and the bytes after the last live mono are zeroed, with the counter moved there.
+// Return the new offset of each live mono by its old offset, even if it stays,
+// for the caller to fix pointers to them.
+//
+// Pointers in and to the monos are not changed here. Error if an offset is not
+// the header of a mono in the region; the region is not changed then.
+func (region *Region) Defragment(liveOffsets []offset) (map[offset]offset, error) {
+	monos, err := region.Monos()
+	if err != nil {
+		return nil, err
+	}
+	headers := map[offset]bool{}
+	for _, mono := range monos {
+		headers[mono.beginOffset] = true
+	}
+	live := map[offset]bool{}
+	for _, at := range liveOffsets {
+		if !headers[at] {
+			return nil, errors.New(fmt.Sprintf(ErrorMessageNotMonoBoundary, at))
+		}
+		live[at] = true
+	}
+
+	// Monos only move toward the beginning, so a mono is never
+	// written over the bytes of a live mono not moved yet.
+	offsets := map[offset]offset{}
+	to := offset(5)
+	for _, mono := range monos {
+		if !live[mono.beginOffset] {
+			continue
+		}
+		size := mono.endOffset - mono.beginOffset
+		copy(region.content[to:to+size], region.content[mono.beginOffset:mono.endOffset])
+		offsets[mono.beginOffset] = to
+		to += size
+	}
+	if err := region.Zero(to, region.counter); err != nil {
+		return nil, err
+	}
+	region.counter = to
+	if err := region.WriteCounter(); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// Regions the allocator and GC keep read their counters again,
+// after the counters are changed through other Region values of the same content.
+func (heap *Heap) readCountersLocked() error {
+	for _, region := range heap.allocator.regions {
+		if err := region.ReadCounter(); err != nil {
+			return err
+		}
+	}
+	if heap.promotion != nil {
+		return heap.promotion.ReadCounter()
+	}
+	return nil
+}
+
+// Addresses of all monos reachable from the roots, including chunks of arrays and
+// dictionaries of objects. It is the marking of Compact, for tests and tools
+// to see which monos are live before they are dropped.
+//
+// Monos are visited breadth-first from the roots, and each one only once,
+// so cycles end. 0 and tagged ints in the roots are not addresses, and are skipped.
+func (heap *Heap) Reachable(roots []address) (map[address]bool, error) {
+	live := map[address]bool{}
+	queue := []address{}
+	for _, root := range roots {
+		if root != 0 && !heap.isTaggedInt(root) && !live[root] {
+			live[root] = true
+			queue = append(queue, root)
+		}
+	}
+	for len(queue) > 0 {
+		addr := queue[0]
+		queue = queue[1:]
+		mono, err := heap.FetchMono(addr)
+		if err != nil {
+			return nil, err
+		}
+		references, parts, err := mono.referencesWithParts()
+		if err != nil {
+			return nil, err
+		}
+		// Parts are live, but their slots are in the references already,
+		// so visiting them would read the rest of the chain again.
+		for _, part := range parts {
+			live[part] = true
+		}
+		for _, reference := range references {
+			if !live[reference] {
+				live[reference] = true
+				queue = append(queue, reference)
+			}
+		}
+	}
+	return live, nil
+}
+
+// Side tables by mono address follow the live monos, and drop the dead ones.
+func (heap *Heap) compactSideTables(forwards map[address]address) {
+	for s, addr := range heap.interned {
+		if moved, exists := forwards[addr]; exists {
+			heap.interned[s] = moved
+		} else {
+			delete(heap.interned, s)
+		}
+	}
+	if heap.sites != nil {
+		sites := make(map[address]uint32)
+		for addr, site := range heap.sites {
+			if moved, exists := forwards[addr]; exists {
+				sites[moved] = site
+			}
+		}
+		heap.sites = sites
+	}
+}
@@ -0,0 +1,167 @@
+package heap
+
+import (
+	"testing"
+)
+
+func TestHeapCompact(t *testing.T) {
+	// Small regions, so pointers cross regions.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 512, NumberRegions: 16})
+	kept := allocateTestArray(t, heap)
+	for i := int32(0); i < 20; i++ {
+		// Holes between the elements.
+		allocateTestFloat64(t, heap, 2.5)
+		if err := kept.Append(allocateTestInt32(t, heap, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	interned, err := heap.Intern("goodtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := heap.Intern("garbage"); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := heap.HashValue(kept.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := heap.Stats().BytesUsed
+	called := []GCStats{}
+	heap.OnGC(func(stats GCStats) {
+		called = append(called, stats)
+	})
+
+	roots := []address{kept.mono.beginFrom, interned}
+	stats, err := heap.Compact(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 float64s and a string are dropped.
+	if compacted := heap.Stats().BytesUsed; compacted != used-20*10-74 {
+		t.Errorf("Heap uses %d bytes after compaction, expect %d", compacted, used-20*10-74)
+	}
+	if stats.BytesReclaimed != 20*10+74 || !stats.Full {
+		t.Errorf("Compaction reclaims %d bytes, full: %v, expect %d and true", stats.BytesReclaimed, stats.Full, 20*10+74)
+	}
+	if stats.MonosCopied == 0 || stats.MonosScanned < stats.MonosCopied {
+		t.Errorf("Compaction copies %d monos and scans %d, expect the moved monos", stats.MonosCopied, stats.MonosScanned)
+	}
+	if len(called) != 1 || called[0] != stats {
+		t.Errorf("OnGC is called with %+v, expect once with %+v", called, stats)
+	}
+	hash, err := heap.HashValue(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != expected {
+		t.Errorf("Array after compaction hashes to %d, expect %d", hash, expected)
+	}
+	if heap.interned["goodtime"] != roots[1] {
+		t.Errorf("Interned string is at %d, expect %d", heap.interned["goodtime"], roots[1])
+	}
+	if _, exists := heap.interned["garbage"]; exists {
+		t.Errorf("Unreachable string is still interned")
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after compaction: %v", err)
+	}
+
+	// Allocation goes on right after the compacted monos,
+	// or the region counter is not where monos end.
+	allocateMono(t, heap, MONO_INT32)
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after allocating: %v", err)
+	}
+}
+
+func TestHeapReachable(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+
+	// foo and bar contain each other.
+	i := allocateTestInt32(t, heap, 1)
+	foo := allocateTestArray(t, heap, i)
+	bar := allocateTestArray(t, heap, foo.mono)
+	if err := foo.Append(bar.mono); err != nil {
+		t.Fatal(err)
+	}
+	// An island: baz and qux contain each other, but nothing reachable contains them.
+	baz := allocateTestArray(t, heap)
+	qux := allocateTestArray(t, heap, baz.mono)
+	if err := baz.Append(qux.mono); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := heap.Reachable([]address{foo.mono.beginFrom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[address]bool{foo.mono.beginFrom: true, bar.mono.beginFrom: true, i.beginFrom: true}
+	if len(live) != len(expected) {
+		t.Errorf("Reachable monos are %v, expect %v", live, expected)
+	}
+	for addr := range expected {
+		if !live[addr] {
+			t.Errorf("Mono at %d is not reachable, expect it is", addr)
+		}
+	}
+	for _, island := range []*WrappedArray{baz, qux} {
+		if live[island.mono.beginFrom] {
+			t.Errorf("Island mono at %d is reachable", island.mono.beginFrom)
+		}
+	}
+
+	// Chunks of a long array are reachable as parts of it, with the elements in them.
+	long := allocateTestArray(t, heap)
+	for n := 0; n < MONO_CHUNK_SIZE*10; n++ {
+		if err := long.Append(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live, err = heap.Reachable([]address{long.mono.beginFrom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The array, 9 chunks after the default one, and the element.
+	if len(live) != 1+9+1 {
+		t.Errorf("%d monos are reachable from the long array, expect %d", len(live), 1+9+1)
+	}
+}
+
+func TestRegionDefragment(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	foo := allocateTestInt32(t, heap, 1)
+	allocateTestFloat64(t, heap, 2.5) // Dead.
+	bar := allocateTestInt32(t, heap, 3)
+	region := foo.region
+
+	// Not a header: nothing changes.
+	if _, err := region.Defragment([]offset{bar.beginOffset + 1}); err == nil {
+		t.Errorf("Expect error when defragmenting with a non-header offset")
+	}
+
+	offsets, err := region.Defragment([]offset{foo.beginOffset, bar.beginOffset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offsets[foo.beginOffset] != foo.beginOffset {
+		t.Errorf("First mono moves to %d, expect it stays at %d", offsets[foo.beginOffset], foo.beginOffset)
+	}
+	if offsets[bar.beginOffset] != foo.endOffset {
+		t.Errorf("Third mono moves to %d, expect %d after the first", offsets[bar.beginOffset], foo.endOffset)
+	}
+	if region.counter != foo.endOffset+6 {
+		t.Errorf("Region counter is %d after defragmenting, expect %d", region.counter, foo.endOffset+6)
+	}
+	moved, err := region.NewMono(MONO_INT32, offsets[bar.beginOffset])
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := region.ReadInt32(moved.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 3 {
+		t.Errorf("Moved mono reads %d, expect %d", i, 3)
+	}
+}
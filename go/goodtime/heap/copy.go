@@ -0,0 +1,136 @@
+package heap
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrorMessageCannotCopyKind = "Cannot copy mono of kind: %d"
+
+// Allocate a copy of the value at the address, and of all monos reachable from it,
+// like `structuredClone` in JavaScript. Return the address of the copied root.
+//
+// The copy has the same shape as the value: a mono shared by different parts
+// of the value is copied once, and the copies share it, and cycles are copied
+// as cycles. Tagged ints in arrays are copied as they are.
+//
+// A value nested deeper than HeapConfig.MaxDepth cannot be copied, so it is an error.
+// What has been allocated before the error is left to GC.
+func (heap *Heap) CopyMono(root address) (address, error) {
+	return heap.copyMono(root, map[address]address{}, 0)
+}
+
+// `copies` are addresses of copied monos by their sources, so shared monos and
+// cycles are copied once. A container is in it before what it contains is copied.
+func (heap *Heap) copyMono(addr address, copies map[address]address, depth int) (address, error) {
+	if copied, exists := copies[addr]; exists {
+		return copied, nil
+	}
+	if depth >= heap.maxDepth {
+		return 0, errors.New(fmt.Sprintf(ErrorMessageTooDeep, heap.maxDepth))
+	}
+	mono, err := heap.FetchMono(addr)
+	if err != nil {
+		return 0, err
+	}
+	allocator := heap.allocator
+
+	switch mono.kind {
+	case MONO_INT32, MONO_UINT16, MONO_INT64, MONO_FLOAT64, MONO_BOOL, MONO_NULL, MONO_UNDEFINED:
+		copied, err := allocator.allocateMono(mono.kind)
+		if err != nil {
+			return 0, err
+		}
+		copy(copied.region.content[copied.valueFromOffset:copied.endOffset],
+			mono.region.content[mono.valueFromOffset:mono.endOffset])
+		copies[addr] = copied.beginFrom
+		return copied.beginFrom, nil
+	case MONO_STRING_S8:
+		ws, err := NewWrappedString(mono)
+		if err != nil {
+			return 0, err
+		}
+		bs, err := ws.ReadBytes()
+		if err != nil {
+			return 0, err
+		}
+		copied, err := allocator.String(string(bs))
+		if err != nil {
+			return 0, err
+		}
+		copies[addr] = copied.mono.beginFrom
+		return copied.mono.beginFrom, nil
+	case MONO_ADDRESS:
+		pointer, err := mono.region.ReadAddress(mono.valueFromOffset)
+		if err != nil {
+			return 0, err
+		}
+		copied, err := allocator.allocateMono(MONO_ADDRESS)
+		if err != nil {
+			return 0, err
+		}
+		copies[addr] = copied.beginFrom
+		if pointer == 0 {
+			return copied.beginFrom, nil
+		}
+		target, err := heap.copyMono(pointer, copies, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		return copied.beginFrom, copied.region.WriteAddressBarrier(copied.valueFromOffset, target)
+	case MONO_ARRAY_S8:
+		wa, err := NewWrappedArray(mono)
+		if err != nil {
+			return 0, err
+		}
+		copied, err := allocator.Array()
+		if err != nil {
+			return 0, err
+		}
+		copies[addr] = copied.mono.beginFrom
+		err = wa.forEachAddress(func(idx uint32, element address) error {
+			if heap.isTaggedInt(element) {
+				return copied.AppendAddress(element)
+			}
+			copiedElement, err := heap.copyMono(element, copies, depth+1)
+			if err != nil {
+				return err
+			}
+			return copied.AppendAddress(copiedElement)
+		})
+		return copied.mono.beginFrom, err
+	case MONO_OBJECT_S8:
+		wo, err := NewWrappedObject(mono)
+		if err != nil {
+			return 0, err
+		}
+		copied, err := allocator.Object()
+		if err != nil {
+			return 0, err
+		}
+		copies[addr] = copied.mono.beginFrom
+		keys, err := wo.Keys()
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			value, err := wo.Get(key)
+			if err != nil {
+				return 0, err
+			}
+			copiedValue, err := heap.copyMono(value.beginFrom, copies, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			target, err := heap.FetchMono(copiedValue)
+			if err != nil {
+				return 0, err
+			}
+			if err := copied.Set(key, target); err != nil {
+				return 0, err
+			}
+		}
+		return copied.mono.beginFrom, nil
+	}
+	return 0, errors.New(fmt.Sprintf(ErrorMessageCannotCopyKind, mono.kind))
+}
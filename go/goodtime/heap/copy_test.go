@@ -0,0 +1,99 @@
+package heap
+
+import (
+	"testing"
+)
+
+func TestCopyMono(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	shared := allocateTestNested(t, heap, 3)
+	wo := allocateTestObject(t, heap)
+	for _, name := range []string{"foo", "bar"} {
+		if err := wo.Set(name, shared.mono); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws, err := heap.allocator.String("goodtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wo.Set("baz", ws.mono); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := heap.CopyMono(wo.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied == wo.mono.beginFrom {
+		t.Fatalf("Copy is at %d, the same address as the source", copied)
+	}
+	equal, err := heap.DeepEqual(wo.mono.beginFrom, copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal {
+		t.Errorf("Copy is not equal to the source")
+	}
+
+	mono, err := heap.FetchMono(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copiedObject, err := NewWrappedObject(mono)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo, err := copiedObject.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := copiedObject.Get("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !foo.Equals(bar) {
+		t.Errorf("Copied properties are at %d and %d, expect the same array", foo.beginFrom, bar.beginFrom)
+	}
+	if foo.Equals(shared.mono) {
+		t.Errorf("Copied property is the source array at %d", foo.beginFrom)
+	}
+}
+
+func TestCopyMonoCycle(t *testing.T) {
+	heap := newTestTaggedHeap(t, HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	wa := allocateTestArray(t, heap, allocateTestInt32(t, heap, 1))
+	if err := wa.Append(wa.mono); err != nil {
+		t.Fatal(err)
+	}
+	if err := wa.AppendAddress(heap.TagInt(7)); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := heap.CopyMono(wa.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mono, err := heap.FetchMono(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copiedArray, err := NewWrappedArray(mono)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := copiedArray.IndexAddress(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self != copied {
+		t.Errorf("Copied array contains %d, expect itself at %d", self, copied)
+	}
+	tagged, err := copiedArray.IndexAddress(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, ok := heap.UntagInt(tagged); !ok || i != 7 {
+		t.Errorf("Copied tagged int is %d (%v), expect %d", i, ok, 7)
+	}
+}
@@ -0,0 +1,166 @@
+package heap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var ErrorMessageBadHeapImage = "Not a heap image: %s"
+var ErrorMessageRegionChecksum = "Region #%d of the heap image is corrupted: checksum %08x vs. %08x"
+
+// The first bytes of a heap image.
+var heapImageMagic = [4]byte{'G', 'T', 'H', 'P'}
+
+// Header of a heap image. All numbers are in LittleEndian.
+type heapImageHeader struct {
+	Magic          [4]byte
+	RegionSize     uint32
+	NumberRegions  uint32 // How many content blocks the heap has, used or not.
+	ContentCounter uint32 // How many content blocks are used as regions.
+}
+
+// Header of each used region in a heap image, followed by `Counter` bytes of the region content.
+//
+// The checksum is in the image, not in the region: the 5 header bytes of a region are
+// all its counter and kind, and monos begin right after them.
+type regionImageHeader struct {
+	Kind     byte
+	Counter  uint32
+	Checksum uint32 // CRC32 (IEEE) of the `Counter` bytes of the region content.
+}
+
+// Write all used regions to `w`, so the heap can be loaded by LoadHeap later,
+// with monos at the same addresses. Unused content blocks are not written
+// since they are all 0.
+//
+// Image:
+//
+// [ heap header ][ region #0 header ][ region #0 content ] ... [ region #N header ][ region #N content ]
+func (heap *Heap) Dump(w io.Writer) error {
+	header := heapImageHeader{
+		Magic:          heapImageMagic,
+		RegionSize:     heap.regionSize,
+		NumberRegions:  uint32(len(heap.content)),
+		ContentCounter: uint32(heap.contentCounter),
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		region := heap.RegionFromContent(i*uint64(heap.regionSize), heap.regionSize, heap.content[i])
+		regionHeader := regionImageHeader{
+			Kind:     region.kind,
+			Counter:  region.counter,
+			Checksum: crc32.ChecksumIEEE(region.content[:region.counter]),
+		}
+		if err := binary.Write(w, binary.LittleEndian, regionHeader); err != nil {
+			return err
+		}
+		if _, err := w.Write(region.content[:region.counter]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read a heap image written by Heap.Dump.
+// The loaded heap has the same sizes and regions, so monos can be fetched by the same addresses.
+//
+// Each region is checked by its checksum before it is used, so a corrupted image
+// fails to load, instead of giving garbage monos.
+func LoadHeap(r io.Reader) (*Heap, error) {
+	var header heapImageHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != heapImageMagic {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageBadHeapImage, "wrong magic"))
+	}
+	if header.ContentCounter > header.NumberRegions {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageBadHeapImage, "more used regions than the heap has"))
+	}
+
+	maxRegions := MAX_NUMBER_REGIONS
+	if int(header.NumberRegions) > maxRegions {
+		maxRegions = int(header.NumberRegions)
+	}
+	cfg := HeapConfig{
+		RegionSize:    header.RegionSize,
+		NumberRegions: int(header.NumberRegions),
+		MaxRegions:    maxRegions,
+	}
+	if err := cfg.Check(); err != nil {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageBadHeapImage, err))
+	}
+	heap := NewHeapWithConfig(cfg)
+	for i := uint32(0); i < header.ContentCounter; i++ {
+		var regionHeader regionImageHeader
+		if err := binary.Read(r, binary.LittleEndian, &regionHeader); err != nil {
+			return nil, err
+		}
+		if regionHeader.Counter > header.RegionSize {
+			return nil, errors.New(fmt.Sprintf(ErrorMessageBadHeapImage, "region counter over the region size"))
+		}
+		if _, err := io.ReadFull(r, heap.content[i][:regionHeader.Counter]); err != nil {
+			return nil, err
+		}
+		if checksum := crc32.ChecksumIEEE(heap.content[i][:regionHeader.Counter]); checksum != regionHeader.Checksum {
+			return nil, errors.New(fmt.Sprintf(ErrorMessageRegionChecksum, i, checksum, regionHeader.Checksum))
+		}
+
+		region, err := heap.NewRegion()
+		if err != nil {
+			return nil, err
+		}
+		if region.kind != regionHeader.Kind || region.counter != regionHeader.Counter {
+			return nil, errors.New(fmt.Sprintf(ErrorMessageBadHeapImage, "region header mismatches its content"))
+		}
+		heap.allocator.regions = append(heap.allocator.regions, region)
+	}
+	return heap, nil
+}
+
+// How many characters the bar of each region takes in DumpMap.
+const dumpMapWidth = 64
+
+// Print a line for each region in use, with a bar of what its bytes are,
+// like to see fragmentation and generations at a glance:
+//
+//	Region 0 [Eden] IIIIII__________IIIIII.......................................... 22/64
+//
+// Each character of the bar is a slice of the bytes after the region header:
+// the first letter of the kind name of the first mono in it, `_` for a gap
+// no mono takes, like a freed mono, or `.` for free bytes after the counter.
+// Letters are only a glance: kinds with the same first letter, like Int32
+// and Int64, share it. The numbers are bytes used and bytes for monos.
+func (heap *Heap) DumpMap(w io.Writer) error {
+	return heap.EachRegion(func(index int, region *Region) error {
+		monos, err := region.Monos()
+		if err != nil {
+			return err
+		}
+		capacity := region.size - 5
+		cellSize := (capacity + dumpMapWidth - 1) / dumpMapWidth
+		bar := make([]byte, 0, dumpMapWidth)
+		for begin := offset(5); begin < region.size; begin += cellSize {
+			end := begin + cellSize
+			// Monos before the cell cannot be in later cells either.
+			for len(monos) > 0 && monos[0].endOffset <= begin {
+				monos = monos[1:]
+			}
+			switch {
+			case len(monos) > 0 && monos[0].beginOffset < end:
+				bar = append(bar, monos[0].KindName()[0])
+			case begin < region.counter:
+				bar = append(bar, '_')
+			default:
+				bar = append(bar, '.')
+			}
+		}
+		_, err = fmt.Fprintf(w, "Region %d [%s] %s %d/%d\n", index, region.KindName(), bar, region.UsedBytes(), capacity)
+		return err
+	})
+}
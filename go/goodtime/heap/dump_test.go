@@ -0,0 +1,146 @@
+package heap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpAndLoadHeap(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+
+	i := allocateTestInt32(t, heap, 42)
+	f := allocateTestFloat64(t, heap, 2.5)
+	nested := allocateTestNested(t, heap, 3)
+	ws, err := heap.allocator.String("goodtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var image bytes.Buffer
+	if err := heap.Dump(&image); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadHeap(&image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.regionSize != heap.regionSize || loaded.contentCounter != heap.contentCounter {
+		t.Fatalf("Loaded heap has region size %d and %d regions, expect %d and %d",
+			loaded.regionSize, loaded.contentCounter, heap.regionSize, heap.contentCounter)
+	}
+
+	loadedI, err := loaded.FetchMono(i.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readI, err := loadedI.region.ReadInt32(loadedI.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readI != 42 {
+		t.Errorf("Loaded int32 reads %d, expect %d", readI, 42)
+	}
+
+	loadedF, err := loaded.FetchMono(f.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readF, err := loadedF.region.ReadFloat64(loadedF.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readF != 2.5 {
+		t.Errorf("Loaded float64 reads %v, expect %v", readF, 2.5)
+	}
+
+	loadedS, err := loaded.FetchMono(ws.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedWs, err := NewWrappedString(loadedS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readS, err := loadedWs.ReadGoString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readS != "goodtime" {
+		t.Errorf("Loaded string reads %q, expect %q", readS, "goodtime")
+	}
+
+	// Nested arrays hash by structure, so an equal hash means all elements are loaded.
+	hashNested, err := heap.HashValue(nested.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashLoaded, err := loaded.HashValue(nested.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashNested != hashLoaded {
+		t.Errorf("Loaded array hashes %d, expect %d", hashLoaded, hashNested)
+	}
+
+	// The loaded heap can still allocate.
+	if _, err := loaded.allocator.Array(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadHeapBadImage(t *testing.T) {
+	if _, err := LoadHeap(bytes.NewReader([]byte("not a heap image at all"))); err == nil {
+		t.Error("Expect error when loading a bad image")
+	}
+}
+
+func TestLoadHeapCorruptedRegion(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocateTestInt32(t, heap, 42)
+
+	var image bytes.Buffer
+	if err := heap.Dump(&image); err != nil {
+		t.Fatal(err)
+	}
+	// The last byte is in the content of the last region.
+	corrupted := image.Bytes()
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	_, err := LoadHeap(bytes.NewReader(corrupted))
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Loading a corrupted image fails with %v, expect a checksum failure", err)
+	}
+}
+
+func TestDumpMap(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 69, NumberRegions: 4})
+	// 64 bytes for monos in each region, so each character is a byte.
+	allocateTestInt32(t, heap, 1)
+	freed := allocateTestFloat64(t, heap, 2.5)
+	allocateTestInt32(t, heap, 3)
+	allocateTestArray(t, heap)
+	if err := heap.Free(freed.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+	newTestTenuredRegion(t, heap)
+
+	var out bytes.Buffer
+	if err := heap.DumpMap(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != heap.RegionCount() {
+		t.Fatalf("DumpMap prints %d lines, expect one for each of %d regions:\n%s", len(lines), heap.RegionCount(), out.String())
+	}
+	expected := "Region 0 [Eden] IIIIII__________IIIIII" + strings.Repeat(".", 42) + " 22/64"
+	if lines[0] != expected {
+		t.Errorf("DumpMap prints %q, expect %q", lines[0], expected)
+	}
+	if !strings.HasPrefix(lines[1], "Region 1 [Eden] "+strings.Repeat("A", 45)) {
+		t.Errorf("DumpMap prints %q for the region of the array", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "Region 2 [Tenured] "+strings.Repeat(".", 64)) {
+		t.Errorf("DumpMap prints %q for the empty Tenured region", lines[2])
+	}
+}
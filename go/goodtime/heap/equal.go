@@ -0,0 +1,182 @@
+package heap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrorMessageCannotCompareKind = "Cannot compare mono of kind: %d"
+
+// A pair of monos being compared.
+type equalPair struct {
+	a address
+	b address
+}
+
+// Compare values at the two addresses by their structure, like HashValue
+// hashes them, so values at different addresses can be equal:
+//
+// - scalars are compared by value; for float64, -0 equals 0, and NaN equals no other mono
+// - strings are compared by their bytes
+// - arrays are compared by their length and elements, recursively
+// - objects are compared by their keys and values, recursively, in any order of properties
+//
+// Monos of different kinds are never equal. A mono is always equal to itself without
+// comparing its value, even a NaN or an array containing one, so values can be keys
+// of a map by HashValue, which needs every key to equal itself.
+//
+// Pairs of monos to compare are kept in a worklist instead of recursion, so deeply
+// nested values don't grow the Go stack. A pair met again, like two arrays containing
+// themselves, is taken as equal, so comparing cycles ends. If they are different,
+// the difference is found elsewhere in the pair.
+func (heap *Heap) DeepEqual(a, b address) (bool, error) {
+	visited := map[equalPair]bool{}
+	worklist := []equalPair{{a, b}}
+	for len(worklist) > 0 {
+		pair := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		if pair.a == pair.b || visited[pair] {
+			continue
+		}
+		visited[pair] = true
+		equal, nested, err := heap.shallowEqual(pair.a, pair.b)
+		if err != nil || !equal {
+			return false, err
+		}
+		worklist = append(worklist, nested...)
+	}
+	return true, nil
+}
+
+// Compare the two monos without what they contain, and return the pairs
+// they contain to compare next, like elements at the same indexes.
+func (heap *Heap) shallowEqual(a, b address) (bool, []equalPair, error) {
+	monoA, err := heap.FetchMono(a)
+	if err != nil {
+		return false, nil, err
+	}
+	monoB, err := heap.FetchMono(b)
+	if err != nil {
+		return false, nil, err
+	}
+	if monoA.kind != monoB.kind {
+		return false, nil, nil
+	}
+
+	switch monoA.kind {
+	case MONO_INT32, MONO_UINT16, MONO_INT64, MONO_BOOL:
+		valueA := monoA.region.content[monoA.valueFromOffset:monoA.endOffset]
+		valueB := monoB.region.content[monoB.valueFromOffset:monoB.endOffset]
+		return bytes.Equal(valueA, valueB), nil, nil
+	case MONO_FLOAT64:
+		f, err := monoA.region.ReadFloat64(monoA.valueFromOffset)
+		if err != nil {
+			return false, nil, err
+		}
+		g, err := monoB.region.ReadFloat64(monoB.valueFromOffset)
+		if err != nil {
+			return false, nil, err
+		}
+		return f == g, nil, nil
+	case MONO_NULL, MONO_UNDEFINED:
+		// The kind is the value.
+		return true, nil, nil
+	case MONO_ADDRESS:
+		pointerA, err := monoA.region.ReadAddress(monoA.valueFromOffset)
+		if err != nil {
+			return false, nil, err
+		}
+		pointerB, err := monoB.region.ReadAddress(monoB.valueFromOffset)
+		if err != nil {
+			return false, nil, err
+		}
+		return true, []equalPair{{pointerA, pointerB}}, nil
+	case MONO_STRING_S8:
+		wsA, err := NewWrappedString(monoA)
+		if err != nil {
+			return false, nil, err
+		}
+		wsB, err := NewWrappedString(monoB)
+		if err != nil {
+			return false, nil, err
+		}
+		equal, err := wsA.Equals(wsB)
+		return equal, nil, err
+	case MONO_ARRAY_S8:
+		return shallowEqualArrays(monoA, monoB)
+	case MONO_OBJECT_S8:
+		return shallowEqualObjects(monoA, monoB)
+	}
+	return false, nil, errors.New(fmt.Sprintf(ErrorMessageCannotCompareKind, monoA.kind))
+}
+
+func shallowEqualArrays(monoA, monoB *Mono) (bool, []equalPair, error) {
+	waA, err := NewWrappedArray(monoA)
+	if err != nil {
+		return false, nil, err
+	}
+	waB, err := NewWrappedArray(monoB)
+	if err != nil {
+		return false, nil, err
+	}
+	elementsA, err := waA.ToSlice()
+	if err != nil {
+		return false, nil, err
+	}
+	elementsB, err := waB.ToSlice()
+	if err != nil {
+		return false, nil, err
+	}
+	if len(elementsA) != len(elementsB) {
+		return false, nil, nil
+	}
+	nested := make([]equalPair, 0, len(elementsA))
+	for i := range elementsA {
+		nested = append(nested, equalPair{elementsA[i].beginFrom, elementsB[i].beginFrom})
+	}
+	return true, nested, nil
+}
+
+func shallowEqualObjects(monoA, monoB *Mono) (bool, []equalPair, error) {
+	woA, err := NewWrappedObject(monoA)
+	if err != nil {
+		return false, nil, err
+	}
+	woB, err := NewWrappedObject(monoB)
+	if err != nil {
+		return false, nil, err
+	}
+	keysA, err := woA.Keys()
+	if err != nil {
+		return false, nil, err
+	}
+	keysB, err := woB.Keys()
+	if err != nil {
+		return false, nil, err
+	}
+	if len(keysA) != len(keysB) {
+		return false, nil, nil
+	}
+	sort.Strings(keysA)
+	sort.Strings(keysB)
+	for i := range keysA {
+		if keysA[i] != keysB[i] {
+			return false, nil, nil
+		}
+	}
+	nested := make([]equalPair, 0, len(keysA))
+	for _, key := range keysA {
+		valueA, err := woA.Get(key)
+		if err != nil {
+			return false, nil, err
+		}
+		valueB, err := woB.Get(key)
+		if err != nil {
+			return false, nil, err
+		}
+		nested = append(nested, equalPair{valueA.beginFrom, valueB.beginFrom})
+	}
+	return true, nested, nil
+}
@@ -0,0 +1,178 @@
+package heap
+
+import (
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDeepEqualArrays(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+
+	deepEqual := func(a, b *WrappedArray) bool {
+		equal, err := heap.DeepEqual(a.mono.beginFrom, b.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return equal
+	}
+
+	foo := allocateTestNested(t, heap, 3)
+	bar := allocateTestNested(t, heap, 3)
+	baz := allocateTestNested(t, heap, 4)
+	if !deepEqual(foo, bar) {
+		t.Errorf("Equal nested arrays at %d and %d are not equal", foo.mono.beginFrom, bar.mono.beginFrom)
+	}
+	if deepEqual(foo, baz) {
+		t.Errorf("Different nested arrays at %d and %d are equal", foo.mono.beginFrom, baz.mono.beginFrom)
+	}
+
+	// The same array twice in one, and two equal arrays in the other.
+	shared := allocateTestNested(t, heap, 5)
+	twice := allocateTestArray(t, heap, shared.mono, shared.mono)
+	pair := allocateTestArray(t, heap, allocateTestNested(t, heap, 5).mono, allocateTestNested(t, heap, 5).mono)
+	if !deepEqual(twice, pair) {
+		t.Errorf("Array with a shared element is not equal to one with equal elements")
+	}
+
+	// Arrays contain themselves.
+	cycleFoo := allocateTestArray(t, heap, allocateTestInt32(t, heap, 1))
+	cycleBar := allocateTestArray(t, heap, allocateTestInt32(t, heap, 1))
+	cycleBaz := allocateTestArray(t, heap, allocateTestInt32(t, heap, 2))
+	for _, wa := range []*WrappedArray{cycleFoo, cycleBar, cycleBaz} {
+		if err := wa.Append(wa.mono); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !deepEqual(cycleFoo, cycleBar) {
+		t.Errorf("Equal cycles are not equal")
+	}
+	if deepEqual(cycleFoo, cycleBaz) {
+		t.Errorf("Different cycles are equal")
+	}
+}
+
+func TestDeepEqualNaN(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	nan := allocateTestFloat64(t, heap, math.NaN())
+	other := allocateTestFloat64(t, heap, math.NaN())
+	deepEqual := func(a, b *Mono) bool {
+		equal, err := heap.DeepEqual(a.beginFrom, b.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return equal
+	}
+
+	if !deepEqual(nan, nan) {
+		t.Errorf("NaN mono is not equal to itself")
+	}
+	if deepEqual(nan, other) {
+		t.Errorf("NaN monos at different addresses are equal")
+	}
+	if !deepEqual(allocateTestArray(t, heap, nan).mono, allocateTestArray(t, heap, nan).mono) {
+		t.Errorf("Arrays of the same NaN mono are not equal")
+	}
+	if deepEqual(allocateTestArray(t, heap, nan).mono, allocateTestArray(t, heap, other).mono) {
+		t.Errorf("Arrays of different NaN monos are equal")
+	}
+}
+
+func TestDeepEqualObjects(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+
+	// {foo: [n, ...], bar: "goodtime"}, with properties set in the given order.
+	allocateObject := func(n int32, names ...string) *WrappedObject {
+		wo := allocateTestObject(t, heap)
+		for _, name := range names {
+			value := allocateTestNested(t, heap, n).mono
+			if name == "bar" {
+				ws, err := heap.allocator.String("goodtime")
+				if err != nil {
+					t.Fatal(err)
+				}
+				value = ws.mono
+			}
+			if err := wo.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return wo
+	}
+	deepEqual := func(a, b *WrappedObject) bool {
+		equal, err := heap.DeepEqual(a.mono.beginFrom, b.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return equal
+	}
+
+	foo := allocateObject(3, "foo", "bar")
+	if !deepEqual(foo, allocateObject(3, "bar", "foo")) {
+		t.Errorf("Objects with equal properties in different orders are not equal")
+	}
+	if deepEqual(foo, allocateObject(4, "foo", "bar")) {
+		t.Errorf("Objects differing in a nested property are equal")
+	}
+	if deepEqual(foo, allocateObject(3, "foo")) {
+		t.Errorf("Objects with different keys are equal")
+	}
+	equal, err := heap.DeepEqual(foo.mono.beginFrom, allocateTestNested(t, heap, 3).mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equal {
+		t.Errorf("Object is equal to an array")
+	}
+}
+
+func TestDeeplyNested(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 1 << 20, NumberRegions: 16})
+	const depth = 100000
+
+	// [[[...[]...]]], allocated from the innermost.
+	allocateDeep := func() *WrappedArray {
+		inner := allocateTestArray(t, heap)
+		for i := 1; i < depth; i++ {
+			inner = allocateTestArray(t, heap, inner.mono)
+		}
+		return inner
+	}
+	foo := allocateDeep()
+	bar := allocateDeep()
+
+	equal, err := heap.DeepEqual(foo.mono.beginFrom, bar.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal {
+		t.Errorf("Equal deeply nested arrays are not equal")
+	}
+	live, err := heap.Reachable([]address{foo.mono.beginFrom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != depth {
+		t.Errorf("%d monos are reachable, expect %d", len(live), depth)
+	}
+	size, err := heap.SizeOfGraph(foo.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != depth*45 {
+		t.Errorf("Size of the graph is %d bytes, expect %d", size, depth*45)
+	}
+
+	// Recursive walks fail instead.
+	if err := heap.ToJSON(foo.mono.beginFrom, ioutil.Discard); err == nil {
+		t.Errorf("Expect error when writing JSON deeper than the max depth")
+	}
+	if _, err := heap.HashValue(foo.mono.beginFrom); err == nil {
+		t.Errorf("Expect error when hashing deeper than the max depth")
+	}
+	deepJSON := strings.Repeat("[", MAX_DEPTH+1) + strings.Repeat("]", MAX_DEPTH+1)
+	if _, err := heap.FromJSON(strings.NewReader(deepJSON)); err == nil {
+		t.Errorf("Expect error when reading JSON deeper than the max depth")
+	}
+}
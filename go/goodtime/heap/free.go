@@ -0,0 +1,212 @@
+package heap
+
+// Explicit deallocation, for hosts managing memory by themselves like in an arena,
+// instead of leaving unreachable monos to GC.
+//
+// A freed mono is zeroed, so its bytes are a gap traversing the region jumps over,
+// and becomes a hole in the free list of its region, which is by the hole size.
+// A later mono is allocated into a hole as large as it first, before bumping
+// the counter of the latest region. Since monos are of a few sizes by their kinds,
+// churning monos of the same kinds reuses the same holes, without splitting them.
+// If there is no such hole, the smallest larger hole is split, and what is left
+// of it goes to the list of its new size. Among regions, the one at the lowest
+// address is taken, so monos gather in the earlier regions.
+//
+// Free lists are by region, so freeing the last mono of a region only looks
+// for holes before it in that region, and GC resetting or compacting a region
+// drops its free list at once, since the bytes of the holes are reused by the GC.
+
+// Bytes of a freed mono, or of what is left of it, in a region.
+type hole struct {
+	region *Region
+	at     offset
+	size   uint32
+}
+
+// Free the mono at the address, so its bytes can be allocated again.
+//
+// Freeing a mono still referenced elsewhere, like by an array or a root, is undefined:
+// the reference points to a gap, or to another mono allocated there later.
+// Freeing a mono twice is undefined as well.
+func (heap *Heap) Free(addr address) error {
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+
+	mono, err := heap.FetchMono(addr)
+	if err != nil {
+		return err
+	}
+	if err := mono.validateInRegion(); err != nil {
+		return err
+	}
+	region := mono.region
+	if err := region.Zero(mono.beginOffset, mono.endOffset); err != nil {
+		return err
+	}
+
+	// Side tables must not tell anything about a mono allocated here later.
+	for slot := mono.beginFrom; slot < mono.endAt; slot++ {
+		delete(heap.remembered, slot)
+	}
+	delete(heap.sites, addr)
+	for s, interned := range heap.interned {
+		if interned == addr {
+			delete(heap.interned, s)
+		}
+	}
+
+	a := heap.allocator
+	a.pushHole(hole{region: region, at: mono.beginOffset, size: mono.endOffset - mono.beginOffset})
+	if mono.endOffset != region.counter {
+		return nil
+	}
+
+	// The last mono of the region: give its bytes back to the counter instead,
+	// with holes right before it, so no gap is left before the counter.
+	for {
+		h, found := a.removeHole(region, func(h hole) bool {
+			return h.at+h.size == region.counter
+		})
+		if !found {
+			break
+		}
+		region.counter = h.at
+	}
+	if err := region.WriteCounter(); err != nil {
+		return err
+	}
+	return heap.readCountersLocked()
+}
+
+// Allocate the mono into a hole as large as it, or the smallest larger hole.
+// Return nil if there is no hole large enough.
+func (a *Allocator) allocateFromHoleLocked(kind byte, size uint32) (*Mono, error) {
+	var h hole
+	var found bool
+	if a.firstFit {
+		h, found = a.takeFirstFit(size)
+	} else {
+		h, found = a.takeBySize(size)
+	}
+	if !found {
+		return nil, nil
+	}
+	mono, err := h.region.NewMono(kind, h.at)
+	if err != nil {
+		return nil, err
+	}
+	if err := mono.WriteHeader(); err != nil {
+		return nil, err
+	}
+	if h.size > size {
+		a.pushHole(hole{region: h.region, at: h.at + size, size: h.size - size})
+	}
+	return mono, nil
+}
+
+// Take a hole as large as the mono, or the smallest larger one, from the region at
+// the lowest address among regions with such a hole.
+func (a *Allocator) takeBySize(size uint32) (hole, bool) {
+	var region uint64
+	holeSize := uint32(0)
+	for beginFrom, bySize := range a.holes {
+		for s := range bySize {
+			if s < size {
+				continue
+			}
+			if holeSize == 0 || s < holeSize || s == holeSize && beginFrom < region {
+				region, holeSize = beginFrom, s
+			}
+		}
+	}
+	if holeSize == 0 {
+		return hole{}, false
+	}
+	return a.popHole(region, holeSize), true
+}
+
+// Take the first hole large enough, by the address it is at.
+func (a *Allocator) takeFirstFit(size uint32) (hole, bool) {
+	var first *hole
+	for _, bySize := range a.holes {
+		for s, holes := range bySize {
+			if s < size {
+				continue
+			}
+			for i := range holes {
+				if first == nil || holes[i].region.beginFrom+uint64(holes[i].at) < first.region.beginFrom+uint64(first.at) {
+					first = &holes[i]
+				}
+			}
+		}
+	}
+	if first == nil {
+		return hole{}, false
+	}
+	at := first.at
+	return a.removeHole(first.region, func(h hole) bool {
+		return h.at == at
+	})
+}
+
+func (a *Allocator) pushHole(h hole) {
+	if a.holes == nil {
+		a.holes = map[uint64]map[uint32][]hole{}
+	}
+	bySize := a.holes[h.region.beginFrom]
+	if bySize == nil {
+		bySize = map[uint32][]hole{}
+		a.holes[h.region.beginFrom] = bySize
+	}
+	bySize[h.size] = append(bySize[h.size], h)
+}
+
+// Take the latest hole of the size in the region, which must have one.
+// Sizes and regions without holes left are removed, so the maps always have holes.
+func (a *Allocator) popHole(region uint64, size uint32) hole {
+	bySize := a.holes[region]
+	holes := bySize[size]
+	h := holes[len(holes)-1]
+	if len(holes) > 1 {
+		bySize[size] = holes[:len(holes)-1]
+		return h
+	}
+	delete(bySize, size)
+	if len(bySize) == 0 {
+		delete(a.holes, region)
+	}
+	return h
+}
+
+// Remove and return a hole in the region the callback returns true for.
+// Return false if there is no such hole.
+func (a *Allocator) removeHole(region *Region, match func(hole) bool) (hole, bool) {
+	bySize := a.holes[region.beginFrom]
+	for size, holes := range bySize {
+		for i, h := range holes {
+			if !match(h) {
+				continue
+			}
+			if len(holes) > 1 {
+				bySize[size] = append(holes[:i], holes[i+1:]...)
+				return h, true
+			}
+			delete(bySize, size)
+			if len(bySize) == 0 {
+				delete(a.holes, region.beginFrom)
+			}
+			return h, true
+		}
+	}
+	return hole{}, false
+}
+
+// Drop the free lists of regions the callback returns true for, by the addresses
+// they begin from, like regions reset by GC.
+func (a *Allocator) dropHoles(cb func(beginFrom uint64) bool) {
+	for beginFrom := range a.holes {
+		if cb(beginFrom) {
+			delete(a.holes, beginFrom)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package heap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFreeReusesHole(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	freed := allocateTestInt32(t, heap, 42)
+	kept := allocateTestFloat64(t, heap, 2.5)
+	region := heap.allocator.latestRegion()
+	counter := region.counter
+
+	if err := heap.Free(freed.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+	for at := freed.beginOffset; at < freed.endOffset; at++ {
+		if region.content[at] != 0 {
+			t.Fatalf("Byte at %d of the freed mono reads %d, expect %d", at, region.content[at], 0)
+		}
+	}
+	monos, err := region.Monos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monos) != 1 || monos[0].beginFrom != kept.beginFrom {
+		t.Errorf("Traversing visits %d monos after free, expect only the one at %d", len(monos), kept.beginFrom)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after free: %v", err)
+	}
+
+	reused := allocateTestInt32(t, heap, 7)
+	if reused.beginFrom != freed.beginFrom {
+		t.Errorf("Mono is allocated at %d, expect at %d where the freed one was", reused.beginFrom, freed.beginFrom)
+	}
+	if region.counter != counter {
+		t.Errorf("Region counter reads %d, expect %d unchanged", region.counter, counter)
+	}
+	// The hole is used up, so the next mono is after the counter.
+	next := allocateTestInt32(t, heap, 8)
+	if next.beginOffset != counter {
+		t.Errorf("Mono is allocated at offset %d, expect %d", next.beginOffset, counter)
+	}
+}
+
+func TestFreeLastMono(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	first := allocateTestInt32(t, heap, 1)
+	middle := allocateTestFloat64(t, heap, 2.5)
+	last := allocateTestInt32(t, heap, 3)
+	region := heap.allocator.latestRegion()
+
+	// The hole of the middle mono is right before the last one,
+	// so both are given back to the counter.
+	for _, mono := range []*Mono{middle, last} {
+		if err := heap.Free(mono.beginFrom); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if region.counter != first.endOffset {
+		t.Errorf("Region counter reads %d, expect %d", region.counter, first.endOffset)
+	}
+	if len(heap.allocator.holes) != 0 {
+		t.Errorf("%d holes are left, expect %d", len(heap.allocator.holes), 0)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after free: %v", err)
+	}
+}
+
+func TestFreeBySize(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	float := allocateTestFloat64(t, heap, 2.5)
+	int32s := []*Mono{allocateTestInt32(t, heap, 1), allocateTestInt32(t, heap, 2)}
+	allocateTestInt32(t, heap, 3) // Keep the holes from going back to the counter.
+	for _, mono := range []*Mono{float, int32s[0]} {
+		if err := heap.Free(mono.beginFrom); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The hole of the int32 is as large, so the float64 hole is not split.
+	reused := allocateTestInt32(t, heap, 4)
+	if reused.beginFrom != int32s[0].beginFrom {
+		t.Errorf("Int32 is allocated at %d, expect at %d where the freed int32 was", reused.beginFrom, int32s[0].beginFrom)
+	}
+	// No int32 hole left, so the float64 hole is split, and the rest is a hole of 4 bytes.
+	split := allocateTestInt32(t, heap, 5)
+	if split.beginFrom != float.beginFrom {
+		t.Errorf("Int32 is allocated at %d, expect at %d where the freed float64 was", split.beginFrom, float.beginFrom)
+	}
+	if rest := heap.allocator.holes[float.region.beginFrom][4]; len(rest) != 1 || rest[0].at != float.beginOffset+6 {
+		t.Errorf("Holes of 4 bytes are %v, expect one at %d", rest, float.beginOffset+6)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after reusing holes: %v", err)
+	}
+}
+
+// Allocate and free monos of mixed kinds, like an interpreter making temporary values
+// while keeping some of them. A random live mono is freed for each one allocated,
+// so holes are left between live monos, and the heap takes more regions only when
+// the holes cannot be reused. Size classes keep holes of each kind whole, while first
+// fit splits larger holes for smaller monos, so fewer of them are reused.
+func BenchmarkFreeChurn(b *testing.B) {
+	for _, strategy := range []struct {
+		name     string
+		firstFit bool
+	}{{"SizeClasses", false}, {"FirstFit", true}} {
+		b.Run(strategy.name, func(b *testing.B) {
+			heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 1024})
+			heap.allocator.firstFit = strategy.firstFit
+			kinds := []byte{MONO_INT32, MONO_FLOAT64, MONO_STRING_S8, MONO_BOOL, MONO_ARRAY_S8}
+			random := rand.New(rand.NewSource(1))
+			live := []address{}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				live = append(live, allocateMono(b, heap, kinds[random.Intn(len(kinds))]).beginFrom)
+				if len(live) < 256 {
+					continue
+				}
+				j := random.Intn(len(live))
+				if err := heap.Free(live[j]); err != nil {
+					b.Fatal(err)
+				}
+				live[j] = live[len(live)-1]
+				live = live[:len(live)-1]
+			}
+			b.ReportMetric(float64(heap.contentCounter), "regions")
+		})
+	}
+}
+
+func TestFreeZeroesMono(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	element := allocateTestInt32(t, heap, 42)
+	freed := allocateTestArray(t, heap, element)
+	allocateTestInt32(t, heap, 7) // Not the last mono, so it stays a hole.
+
+	if err := heap.Free(freed.mono.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+	region := freed.mono.region
+	kind, err := region.ReadByte(freed.mono.beginOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != 0 {
+		t.Errorf("Header of the freed mono reads kind %d, expect %d", kind, 0)
+	}
+	// The slot pointed to the element, which must not look alive anymore.
+	pointer, err := region.ReadAddress(freed.defaultChunk.OffsetFromIndex(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pointer != 0 {
+		t.Errorf("Slot of the freed array reads %d, expect %d", pointer, 0)
+	}
+	for at := freed.mono.beginOffset; at < freed.mono.endOffset; at++ {
+		if region.content[at] != 0 {
+			t.Errorf("Byte at %d of the freed mono reads %d, expect %d", at, region.content[at], 0)
+		}
+	}
+
+	if err := region.Zero(region.size-2, region.size+2); err == nil {
+		t.Errorf("Expect error when zeroing over the region end")
+	}
+}
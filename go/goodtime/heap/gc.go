@@ -0,0 +1,550 @@
+package heap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrorMessageCannotForward = "Mono of kind %d is too small for a forward: %d bytes"
+var ErrorMessageNotForward = "Mono of kind %d is not a forward"
+
+// Minor GC collects young regions (Eden and Survivor) only. Live young monos
+// are copied into new Survivor regions, and pointers to them are updated.
+// A mono surviving more minor GCs than HeapConfig.TenureThreshold is copied into
+// a Tenured region instead, so later minor GCs don't copy it again.
+//
+// Monos are live if they are reachable from the roots, or from the remembered
+// set: slots in old regions which point to young monos. Old regions are not
+// traversed, so a pointer from an old region to a young mono must be written
+// by Region.WriteAddressBarrier, or the young mono may be collected even if
+// the old one is still in use.
+
+// Write the pointer like WriteAddress, and remember the slot if it is in an old
+// (Tenured) region while the target is young, so minor GC finds the target.
+// Writing 0 clears a slot, and there is nothing to remember.
+//
+// The remembered set is shared by the heap, so remembering takes the heap lock.
+func (region *Region) WriteAddressBarrier(at offset, target address) error {
+	if err := region.WriteAddress(at, target); err != nil {
+		return err
+	}
+	if !region.remembers(target) {
+		return nil
+	}
+	region.heap.mu.Lock()
+	defer region.heap.mu.Unlock()
+	region.heap.rememberLocked(region.beginFrom + uint64(at))
+	return nil
+}
+
+// Like WriteAddressBarrier, but with the heap lock held, like by GC.
+func (region *Region) writeAddressBarrierLocked(at offset, target address) error {
+	if err := region.WriteAddress(at, target); err != nil {
+		return err
+	}
+	if region.remembers(target) {
+		region.heap.rememberLocked(region.beginFrom + uint64(at))
+	}
+	return nil
+}
+
+// If a slot in the region pointing to the target must be remembered.
+func (region *Region) remembers(target address) bool {
+	return target != 0 && !region.heap.isYoung(region.beginFrom) && region.heap.isYoung(target)
+}
+
+func (heap *Heap) rememberLocked(slot address) {
+	if heap.remembered == nil {
+		heap.remembered = make(map[address]bool)
+	}
+	heap.remembered[slot] = true
+}
+
+// If the address is in an Eden or Survivor region.
+func (heap *Heap) isYoung(addr address) bool {
+	kind := heap.regionKindAt(addr)
+	return kind == REGION_EDEN || kind == REGION_SURVIVOR
+}
+
+// Call the callback with each region in use of the kind, like REGION_EDEN, in the order
+// of their addresses, so a GC scanning one generation doesn't form regions of others.
+// Stop at the first error the callback returns, and return it.
+func (heap *Heap) WalkGeneration(kind byte, cb func(*Region) error) error {
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		beginFrom := i * uint64(heap.regionSize)
+		if heap.regionKindAt(beginFrom) != kind {
+			continue
+		}
+		if err := cb(heap.RegionFromContent(beginFrom, heap.regionSize, heap.content[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The kind of the region where the address is, or 0 if it is not in a region in use.
+func (heap *Heap) regionKindAt(addr address) byte {
+	contentIndex := addr / uint64(heap.regionSize)
+	if contentIndex >= heap.contentCounter {
+		return 0
+	}
+	kind := heap.content[contentIndex][4]
+	// A region not created by CreateMono yet reads 0, which is Eden; see ReadKind.
+	if kind == 0 {
+		return REGION_EDEN
+	}
+	return kind
+}
+
+// Collect young regions. `roots` are addresses of monos the caller still uses,
+// like values on the interpreter stack, and are updated in place to where the
+// monos are moved. Other monos the caller holds, and their wrappers, are stale
+// after the GC, so fetch them again from the roots.
+//
+// The regions collected are reset and reused by the allocator after the GC,
+// so survivors are copied into free regions from previous GCs, or new content
+// blocks. If the heap runs out of them in the middle of a GC, it fails with
+// ErrHeapFull, and the heap is not consistent anymore.
+func (heap *Heap) MinorGC(roots []address) (GCStats, error) {
+	heap.mu.Lock()
+	stats, err := heap.minorGCLocked(roots)
+	heap.mu.Unlock()
+	if err != nil {
+		return stats, err
+	}
+	// Out of the lock, so the callback can allocate.
+	if heap.onGC != nil {
+		heap.onGC(stats)
+	}
+	return stats, nil
+}
+
+func (heap *Heap) minorGCLocked(roots []address) (GCStats, error) {
+	begin := time.Now()
+	gc := &minorGC{
+		heap:      heap,
+		fromSpace: map[uint64]bool{},
+		forwards:  map[address]address{},
+	}
+	if err := gc.collect(roots); err != nil {
+		return gc.stats, err
+	}
+	gc.stats.Duration = time.Since(begin)
+	return gc.stats, nil
+}
+
+// What a GC did, for tuning the collector.
+type GCStats struct {
+	// Monos whose pointers are updated, after they are copied.
+	MonosScanned uint64
+
+	// Monos copied to Survivor or Tenured regions, or slid toward the beginning
+	// of their region by Compact.
+	MonosCopied uint64
+
+	// Bytes used in the collected regions, but not copied out of them.
+	BytesReclaimed uint64
+
+	Duration time.Duration
+
+	// If the GC is Compact, of all regions, instead of MinorGC.
+	Full bool
+}
+
+// Call the callback after each GC, like to log how long it takes. It is called for
+// MinorGC and Compact, and each of them automatic GC runs.
+// Only the last callback set is called, and nil means no callback.
+func (heap *Heap) OnGC(callback func(GCStats)) {
+	heap.onGC = callback
+}
+
+func (gc *minorGC) collect(roots []address) error {
+	heap := gc.heap
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		if heap.isYoung(i * uint64(heap.regionSize)) {
+			gc.fromSpace[i] = true
+		}
+	}
+	// Free regions are Eden, but empty; survivors may be copied into them.
+	for _, region := range heap.allocator.free {
+		delete(gc.fromSpace, region.beginFrom/uint64(heap.regionSize))
+	}
+
+	for i, root := range roots {
+		moved, err := gc.evacuate(root)
+		if err != nil {
+			return err
+		}
+		roots[i] = moved
+	}
+	for slot := range heap.remembered {
+		if err := gc.updateSlot(slot); err != nil {
+			return err
+		}
+	}
+	if err := gc.scan(); err != nil {
+		return err
+	}
+
+	// Slots pointing to old monos now are not needed anymore.
+	for slot := range heap.remembered {
+		pointer, err := gc.readSlot(slot)
+		if err != nil {
+			return err
+		}
+		if pointer == 0 || !heap.isYoung(pointer) {
+			delete(heap.remembered, slot)
+		}
+	}
+	if err := gc.updateSideTables(); err != nil {
+		return err
+	}
+
+	// Allocate from a free Eden region, instead of the collected ones.
+	regions := []*Region{}
+	for _, region := range heap.allocator.regions {
+		if !gc.inFromSpace(region.beginFrom) {
+			regions = append(regions, region)
+		}
+	}
+	heap.allocator.regions = regions
+	heap.allocator.dropHoles(gc.inFromSpace)
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		if !gc.fromSpace[i] {
+			continue
+		}
+		region, _, err := gc.heap.RegionOf(i * uint64(heap.regionSize))
+		if err != nil {
+			return err
+		}
+		gc.stats.BytesReclaimed += uint64(region.UsedBytes())
+		if err := region.Reset(); err != nil {
+			return err
+		}
+		heap.allocator.free = append(heap.allocator.free, region)
+	}
+	gc.stats.BytesReclaimed -= gc.bytesCopied
+	return nil
+}
+
+type minorGC struct {
+	heap *Heap
+
+	// Indexes of content blocks being collected.
+	fromSpace map[uint64]bool
+
+	// Where the Survivor monos are copied to.
+	toSpace *Region
+
+	// From the old address of a copied mono to its new address,
+	// for monos too small to have a forward written over them.
+	forwards map[address]address
+
+	// Copied monos whose pointers are not updated yet.
+	queue []*Mono
+
+	bytesCopied uint64
+	stats       GCStats
+}
+
+func (gc *minorGC) inFromSpace(addr address) bool {
+	return gc.fromSpace[addr/uint64(gc.heap.regionSize)]
+}
+
+// Copy the mono at the address to the Survivor region, if it is in the
+// collected regions and not copied yet. Return where the mono is now.
+func (gc *minorGC) evacuate(addr address) (address, error) {
+	if addr == 0 || gc.heap.isTaggedInt(addr) || !gc.inFromSpace(addr) {
+		return addr, nil
+	}
+	moved, exists, err := gc.forwarded(addr)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return moved, nil
+	}
+	mono, err := gc.heap.FetchMono(addr)
+	if err != nil {
+		return 0, err
+	}
+	size := mono.endOffset - mono.beginOffset
+
+	var copied *Mono
+	age, err := mono.ReadAge()
+	if err != nil {
+		return 0, err
+	}
+	age += 1
+	if age > gc.heap.tenureThreshold {
+		gc.heap.promotion, err = gc.regionFor(gc.heap.promotion, REGION_TENURED, size)
+		if err != nil {
+			return 0, err
+		}
+		copied, err = mono.Copy(gc.heap.promotion)
+	} else {
+		gc.toSpace, err = gc.regionFor(gc.toSpace, REGION_SURVIVOR, size)
+		if err != nil {
+			return 0, err
+		}
+		copied, err = mono.Copy(gc.toSpace)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Tenured monos don't age anymore.
+	if copied.region.kind != REGION_SURVIVOR {
+		age = 0
+	}
+	if err := copied.WriteAge(age); err != nil {
+		return 0, err
+	}
+	if mono.canForward() {
+		if err := mono.WriteForward(copied.beginFrom); err != nil {
+			return 0, err
+		}
+	} else {
+		gc.forwards[addr] = copied.beginFrom
+	}
+	gc.stats.MonosCopied += 1
+	gc.bytesCopied += uint64(size)
+	gc.queue = append(gc.queue, copied)
+	return copied.beginFrom, nil
+}
+
+// Where the mono at the address is copied to, if it is, by its forward
+// or by the table.
+func (gc *minorGC) forwarded(addr address) (address, bool, error) {
+	if moved, exists := gc.forwards[addr]; exists {
+		return moved, true, nil
+	}
+	region, at, err := gc.heap.RegionOf(addr)
+	if err != nil {
+		return 0, false, err
+	}
+	kind, err := region.ReadByte(at)
+	if err != nil {
+		return 0, false, err
+	}
+	if kind != MONO_FORWARD {
+		return 0, false, nil
+	}
+	mono, err := gc.heap.FetchMono(addr)
+	if err != nil {
+		return 0, false, err
+	}
+	moved, err := mono.ReadForward()
+	if err != nil {
+		return 0, false, err
+	}
+	return moved, true, nil
+}
+
+// Return the region if it can take `size` more bytes, or a new region of the kind.
+func (gc *minorGC) regionFor(region *Region, kind byte, size uint32) (*Region, error) {
+	if region != nil && region.capable(size) {
+		return region, nil
+	}
+	region, err := gc.heap.allocator.newRegionLocked()
+	if err != nil {
+		return nil, err
+	}
+	if err := region.WriteKind(kind); err != nil {
+		return nil, err
+	}
+	return region, nil
+}
+
+// Update pointers in copied monos, which may copy more monos, until all
+// reachable young monos are copied.
+func (gc *minorGC) scan() error {
+	for len(gc.queue) > 0 {
+		mono := gc.queue[0]
+		gc.queue = gc.queue[1:]
+		gc.stats.MonosScanned += 1
+		slots, err := mono.pointerSlots()
+		if err != nil {
+			return err
+		}
+		for _, at := range slots {
+			if err := gc.updateSlot(mono.region.beginFrom + uint64(at)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Evacuate the target of the pointer at the slot address, and write where it is now.
+func (gc *minorGC) updateSlot(slot address) error {
+	region, at, err := gc.heap.RegionOf(slot)
+	if err != nil {
+		return err
+	}
+	pointer, err := region.ReadAddress(at)
+	if err != nil {
+		return err
+	}
+	moved, err := gc.evacuate(pointer)
+	if err != nil {
+		return err
+	}
+	if moved == pointer {
+		return nil
+	}
+	return region.writeAddressBarrierLocked(at, moved)
+}
+
+func (gc *minorGC) readSlot(slot address) (address, error) {
+	region, at, err := gc.heap.RegionOf(slot)
+	if err != nil {
+		return 0, err
+	}
+	return region.ReadAddress(at)
+}
+
+// Interned strings and site tags are by address, so they follow the copied
+// monos, and the ones of collected monos are dropped.
+func (gc *minorGC) updateSideTables() error {
+	for s, addr := range gc.heap.interned {
+		if !gc.inFromSpace(addr) {
+			continue
+		}
+		moved, exists, err := gc.forwarded(addr)
+		if err != nil {
+			return err
+		}
+		if exists {
+			gc.heap.interned[s] = moved
+		} else {
+			delete(gc.heap.interned, s)
+		}
+	}
+
+	if gc.heap.sites == nil {
+		return nil
+	}
+	sites := make(map[address]uint32)
+	for addr, site := range gc.heap.sites {
+		if !gc.inFromSpace(addr) {
+			sites[addr] = site
+			continue
+		}
+		moved, exists, err := gc.forwarded(addr)
+		if err != nil {
+			return err
+		}
+		if exists {
+			sites[moved] = site
+		}
+	}
+	gc.heap.sites = sites
+	return nil
+}
+
+// Copy all bytes of the mono verbatim to the end of the `dest` region, as a new
+// mono, and advance the region counter. Return the copy.
+//
+// Pointers in the copy are the same as the original ones, and pointers to the
+// original mono are not changed either. Fixing them up is the collector's job.
+func (mono *Mono) Copy(dest *Region) (*Mono, error) {
+	size := mono.endOffset - mono.beginOffset
+	if !dest.capable(size) {
+		return nil, fmt.Errorf(ErrorMessageRegionFull, ErrRegionFull, size)
+	}
+	copied, err := dest.NewMono(mono.kind, dest.counter)
+	if err != nil {
+		return nil, err
+	}
+	copy(dest.content[copied.beginOffset:copied.endOffset],
+		mono.region.content[mono.beginOffset:mono.endOffset])
+
+	dest.counter += size
+	if err := dest.WriteCounter(); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+// How many minor GCs the mono has survived in Survivor regions, from the high bits
+// of its flags byte. Monos not in Survivor regions are 0.
+func (mono *Mono) ReadAge() (uint8, error) {
+	flags, err := mono.region.ReadByte(mono.beginOffset + 1)
+	if err != nil {
+		return 0, err
+	}
+	return flags >> MONO_AGE_SHIFT, nil
+}
+
+// Write the age, up to MONO_MAX_AGE, into the header. Flags are kept.
+func (mono *Mono) WriteAge(age uint8) error {
+	if age > MONO_MAX_AGE {
+		age = MONO_MAX_AGE
+	}
+	flags, err := mono.region.ReadByte(mono.beginOffset + 1)
+	if err != nil {
+		return err
+	}
+	flags = flags&(1<<MONO_AGE_SHIFT-1) | age<<MONO_AGE_SHIFT
+	return mono.region.WriteByte(mono.beginOffset+1, flags)
+}
+
+// Turn the mono into a forward to the `moved` address, after it is copied there,
+// so other pointers to the mono can find where it is now. The forward is written
+// over the mono, so the mono must be at least as large as a forward.
+//
+// The rest of the mono after the forward is zeroed, so traversing the region
+// jumps over the forward and the gap after it.
+func (mono *Mono) WriteForward(moved address) error {
+	if !mono.canForward() {
+		return errors.New(fmt.Sprintf(ErrorMessageCannotForward, mono.kind, mono.endOffset-mono.beginOffset))
+	}
+	end := mono.endOffset
+	forward, err := mono.region.NewMono(MONO_FORWARD, mono.beginOffset)
+	if err != nil {
+		return err
+	}
+	if err := forward.WriteHeader(); err != nil {
+		return err
+	}
+	if err := forward.region.WriteUint64(forward.valueFromOffset, moved); err != nil {
+		return err
+	}
+	if err := forward.region.Zero(forward.endOffset, end); err != nil {
+		return err
+	}
+	*mono = *forward
+	return nil
+}
+
+// Read where the mono is moved to, if it is a forward.
+func (mono *Mono) ReadForward() (address, error) {
+	if mono.kind != MONO_FORWARD {
+		return 0, errors.New(fmt.Sprintf(ErrorMessageNotForward, mono.kind))
+	}
+	return mono.region.ReadUint64(mono.valueFromOffset)
+}
+
+// If the mono is large enough to write a forward over it.
+func (mono *Mono) canForward() bool {
+	size, _ := monoSizeFromKind(MONO_FORWARD)
+	return mono.endOffset-mono.beginOffset >= size
+}
+
+// Like FetchMono, but if the mono at the address is a forward, fetch the mono
+// it is moved to instead, like during a GC.
+func (heap *Heap) FetchMonoFollowingForward(addr address) (*Mono, error) {
+	for {
+		mono, err := heap.FetchMono(addr)
+		if err != nil {
+			return nil, err
+		}
+		if mono.kind != MONO_FORWARD {
+			return mono, nil
+		}
+		if addr, err = mono.ReadForward(); err != nil {
+			return nil, err
+		}
+	}
+}
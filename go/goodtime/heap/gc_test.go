@@ -0,0 +1,401 @@
+package heap
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A region created outside the allocator, marked as Tenured.
+func newTestTenuredRegion(tb testing.TB, heap *Heap) *Region {
+	region, err := heap.NewRegion()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := region.WriteKind(REGION_TENURED); err != nil {
+		tb.Fatal(err)
+	}
+	return region
+}
+
+func TestWalkGeneration(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 64, NumberRegions: 8})
+	// Eden regions around a Tenured one.
+	allocateTestInt32(t, heap, 1)
+	tenured := newTestTenuredRegion(t, heap)
+	for i := int32(0); i < 10; i++ {
+		allocateTestInt32(t, heap, i)
+	}
+
+	visited := []address{}
+	err := heap.WalkGeneration(REGION_EDEN, func(region *Region) error {
+		if region.kind != REGION_EDEN {
+			t.Errorf("Visited region at %d of kind %d, expect Eden", region.beginFrom, region.kind)
+		}
+		visited = append(visited, region.beginFrom)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != int(heap.contentCounter)-1 {
+		t.Errorf("Visited %d Eden regions, expect %d", len(visited), heap.contentCounter-1)
+	}
+	for _, beginFrom := range visited {
+		if beginFrom == tenured.beginFrom {
+			t.Errorf("Visited the Tenured region at %d", beginFrom)
+		}
+	}
+}
+
+func TestMinorGCRememberedSet(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	tenured := newTestTenuredRegion(t, heap)
+	holder, err := tenured.CreateMono(MONO_ADDRESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocateTestInt32(t, heap, 7) // Garbage.
+	young := allocateTestInt32(t, heap, 42)
+	if err := tenured.WriteAddressBarrier(holder.valueFromOffset, young.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+	if !heap.remembered[holder.valueFrom] {
+		t.Fatalf("Slot at %d is not remembered", holder.valueFrom)
+	}
+
+	// No roots: the young int32 is only reachable from the tenured region.
+	if _, err := heap.MinorGC(nil); err != nil {
+		t.Fatal(err)
+	}
+	pointer, err := tenured.ReadAddress(holder.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pointer == young.beginFrom {
+		t.Fatalf("Pointer from the tenured region is still to the collected mono at %d", pointer)
+	}
+	survivor, err := heap.FetchMono(pointer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if survivor.kind != MONO_INT32 || survivor.region.kind != REGION_SURVIVOR {
+		t.Fatalf("Mono after GC is of kind %d in a region of kind %d, expect %d in %d",
+			survivor.kind, survivor.region.kind, MONO_INT32, REGION_SURVIVOR)
+	}
+	i, err := survivor.region.ReadInt32(survivor.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 42 {
+		t.Errorf("Int32 after GC reads %d, expect %d", i, 42)
+	}
+	// Only the survivor is copied, not the garbage.
+	if survivor.region.counter != 5+6 {
+		t.Errorf("Survivor region counter reads %d, expect %d", survivor.region.counter, 5+6)
+	}
+	// The survivor is still young, so the slot is still remembered.
+	if !heap.remembered[holder.valueFrom] {
+		t.Errorf("Slot at %d is not remembered after GC", holder.valueFrom)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after GC: %v", err)
+	}
+}
+
+func TestMinorGCRoots(t *testing.T) {
+	// Small regions, so survivors take more than one region.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 512, NumberRegions: 32})
+	nested := allocateTestNested(t, heap, 3)
+	expected, err := heap.HashValue(nested.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interned, err := heap.Intern("goodtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := heap.Intern("garbage"); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := []address{nested.mono.beginFrom, interned}
+	if _, err := heap.MinorGC(roots); err != nil {
+		t.Fatal(err)
+	}
+	if roots[0] == nested.mono.beginFrom {
+		t.Errorf("Root is still %d after GC, expect the address it is moved to", roots[0])
+	}
+	hash, err := heap.HashValue(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != expected {
+		t.Errorf("Nested array after GC hashes to %d, expect %d", hash, expected)
+	}
+
+	// Interned strings follow the moved monos, and unreachable ones are dropped.
+	if heap.interned["goodtime"] != roots[1] {
+		t.Errorf("Interned string is at %d, expect %d", heap.interned["goodtime"], roots[1])
+	}
+	if _, exists := heap.interned["garbage"]; exists {
+		t.Errorf("Unreachable string is still interned")
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after GC: %v", err)
+	}
+
+	// New monos are allocated in the collected regions, which are reset.
+	mono := allocateMono(t, heap, MONO_INT32)
+	if heap.regionKindAt(mono.beginFrom) != REGION_EDEN || mono.beginFrom > roots[0] || mono.beginOffset != 5 {
+		t.Errorf("Mono allocated after GC is at %d, expect at offset 5 of a collected region", mono.beginFrom)
+	}
+}
+
+func TestMinorGCTenuring(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 16, TenureThreshold: 2})
+	wa := allocateTestArray(t, heap, allocateTestInt32(t, heap, 42))
+
+	if err := wa.mono.SetFlag(1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := []address{wa.mono.beginFrom}
+	for i, expected := range []struct {
+		kind byte
+		age  uint8
+	}{{REGION_SURVIVOR, 1}, {REGION_SURVIVOR, 2}, {REGION_TENURED, 0}, {REGION_TENURED, 0}} {
+		if _, err := heap.MinorGC(roots); err != nil {
+			t.Fatal(err)
+		}
+		if kind := heap.regionKindAt(roots[0]); kind != expected.kind {
+			t.Errorf("After GC #%d, array is in a region of kind %d, expect %d", i+1, kind, expected.kind)
+		}
+		// The age is in the header, with the flags.
+		array, err := heap.FetchMono(roots[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		age, err := array.ReadAge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if age != expected.age {
+			t.Errorf("After GC #%d, array is %d old, expect %d", i+1, age, expected.age)
+		}
+		if set, err := array.GetFlag(1); err != nil || !set {
+			t.Errorf("After GC #%d, the flag of the array is %t (%v), expect it kept", i+1, set, err)
+		}
+	}
+
+	// The element is promoted with the array.
+	array, err := heap.FetchMono(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa, err = NewWrappedArray(array)
+	if err != nil {
+		t.Fatal(err)
+	}
+	element, err := wa.Index(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind := heap.regionKindAt(element.beginFrom); kind != REGION_TENURED {
+		t.Errorf("Element is in a region of kind %d, expect %d", kind, REGION_TENURED)
+	}
+	if age, err := element.ReadAge(); err != nil || age != 0 {
+		t.Errorf("Tenured element is %d old (%v), expect %d", age, err, 0)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after GC: %v", err)
+	}
+}
+
+func TestMonoCopy(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	mono := allocateTestFloat64(t, heap, 3.14)
+	dest, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := mono.Copy(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.beginFrom != dest.beginFrom+5 || copied.kind != MONO_FLOAT64 {
+		t.Errorf("Copy is at %d of kind %d, expect at %d of kind %d",
+			copied.beginFrom, copied.kind, dest.beginFrom+5, MONO_FLOAT64)
+	}
+	if dest.counter != 5+10 {
+		t.Errorf("Region counter reads %d after copy, expect %d", dest.counter, 5+10)
+	}
+	original := mono.region.content[mono.beginOffset:mono.endOffset]
+	payload := dest.content[copied.beginOffset:copied.endOffset]
+	if !bytes.Equal(payload, original) {
+		t.Errorf("Copy has bytes %v, expect %v", payload, original)
+	}
+
+	fetched, err := heap.FetchMono(copied.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fetched.region.ReadFloat64(fetched.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != 3.14 {
+		t.Errorf("Copy reads %f, expect %f", f, 3.14)
+	}
+}
+
+func TestMonoForward(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	shared := allocateTestArray(t, heap, allocateTestInt32(t, heap, 42))
+	first := allocateTestArray(t, heap, shared.mono)
+	second := allocateTestArray(t, heap, shared.mono)
+
+	dest, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := shared.mono.Copy(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := shared.mono.beginFrom
+	if err := shared.mono.WriteForward(copied.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := shared.mono.ReadForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != copied.beginFrom {
+		t.Errorf("Forward reads %d, expect %d", moved, copied.beginFrom)
+	}
+
+	// Both references are redirected by the forward.
+	for _, wa := range []*WrappedArray{first, second} {
+		pointer, err := wa.defaultChunk.mono.region.ReadAddress(wa.defaultChunk.OffsetFromIndex(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pointer != old {
+			t.Fatalf("Reference reads %d, expect %d", pointer, old)
+		}
+		mono, err := heap.FetchMonoFollowingForward(pointer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.beginFrom != copied.beginFrom || mono.kind != MONO_ARRAY_S8 {
+			t.Errorf("Reference is redirected to %d of kind %d, expect %d of kind %d",
+				mono.beginFrom, mono.kind, copied.beginFrom, MONO_ARRAY_S8)
+		}
+	}
+
+	// Too small for a forward.
+	small := allocateMono(t, heap, MONO_BOOL)
+	if err := small.WriteForward(copied.beginFrom); err == nil {
+		t.Errorf("Expect error when writing a forward over a bool")
+	}
+}
+
+func TestTraverseSkipsForward(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	first := allocateTestInt32(t, heap, 1)
+	moved := allocateTestArray(t, heap)
+	last := allocateTestInt32(t, heap, 2)
+	region := first.region
+	if moved.mono.region != region || last.region != region {
+		t.Fatal("Expect monos in the same region")
+	}
+
+	dest, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := moved.mono.Copy(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := moved.mono.WriteForward(copied.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+
+	// The forward is shorter than the array, so a gap follows it.
+	monos, err := region.Monos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monos) != 2 || monos[0].beginFrom != first.beginFrom || monos[1].beginFrom != last.beginFrom {
+		t.Fatalf("Traversing visits %v, expect only the monos at %d and %d", monos, first.beginFrom, last.beginFrom)
+	}
+	if _, err := heap.FetchMono(last.beginFrom); err != nil {
+		t.Errorf("Mono after the forward cannot be fetched: %v", err)
+	}
+}
+
+func TestMinorGCForward(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	shared := allocateTestArray(t, heap, allocateTestInt32(t, heap, 42))
+	first := allocateTestArray(t, heap, shared.mono)
+	second := allocateTestArray(t, heap, shared.mono)
+
+	roots := []address{first.mono.beginFrom, second.mono.beginFrom}
+	if _, err := heap.MinorGC(roots); err != nil {
+		t.Fatal(err)
+	}
+	elements := []address{}
+	for _, root := range roots {
+		mono, err := heap.FetchMono(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wa, err := NewWrappedArray(mono)
+		if err != nil {
+			t.Fatal(err)
+		}
+		element, err := wa.Index(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elements = append(elements, element.beginFrom)
+	}
+	// Copied once, not once for each reference.
+	if elements[0] != elements[1] {
+		t.Errorf("References after GC are to %d and %d, expect the same mono", elements[0], elements[1])
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after GC: %v", err)
+	}
+}
+
+func TestMinorGCStats(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	called := []GCStats{}
+	heap.OnGC(func(stats GCStats) {
+		called = append(called, stats)
+	})
+
+	kept := allocateTestArray(t, heap, allocateTestInt32(t, heap, 1))
+	// Dropped: nothing points to them.
+	allocateTestFloat64(t, heap, 2.5)
+	allocateTestFloat64(t, heap, 3.5)
+
+	stats, err := heap.MinorGC([]address{kept.mono.beginFrom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.MonosCopied != 2 || stats.MonosScanned != 2 {
+		t.Errorf("GC copies %d monos and scans %d, expect %d and %d",
+			stats.MonosCopied, stats.MonosScanned, 2, 2)
+	}
+	if stats.BytesReclaimed != 10+10 {
+		t.Errorf("GC reclaims %d bytes, expect %d", stats.BytesReclaimed, 10+10)
+	}
+	if len(called) != 1 || called[0] != stats {
+		t.Errorf("OnGC is called with %+v, expect once with %+v", called, stats)
+	}
+}
@@ -0,0 +1,170 @@
+package heap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+var ErrorMessageCannotHashKind = "Cannot hash mono of kind: %d"
+
+// Written instead of a mono when hashing meets a mono already being hashed.
+const hashCycleMarker = 0xFF
+
+// Hash the value at the address by its structure, not by where it is,
+// so it can be used as a map key or in equality caches:
+//
+// - scalars are hashed by their bytes; null and undefined by their kind
+// - strings are hashed by their content
+// - arrays are hashed by their length and elements, recursively
+// - objects are hashed by their keys and values, recursively, in the order of sorted keys
+//
+// Equal values hash equally, even if they live at different addresses, and objects
+// with the same properties set in different orders, like DeepEqual takes them.
+//
+// For cycles, like an array contains itself, a mono which is already being
+// hashed is hashed by how deep it is on the way from the root, so two cycles with the
+// same shape still hash equally.
+//
+// A value nested deeper than HeapConfig.MaxDepth cannot be hashed, so it is an error.
+func (heap *Heap) HashValue(addr address) (uint64, error) {
+	return heap.hashValue(addr, map[address]int{})
+}
+
+// The `path` is monos being hashed from the root to here, with their depth.
+func (heap *Heap) hashValue(addr address, path map[address]int) (uint64, error) {
+	h := fnv.New64a()
+	if depth, ok := path[addr]; ok {
+		h.Write([]byte{hashCycleMarker})
+		writeHashUint32(h, uint32(depth))
+		return h.Sum64(), nil
+	}
+	if len(path) >= heap.maxDepth {
+		return 0, errors.New(fmt.Sprintf(ErrorMessageTooDeep, heap.maxDepth))
+	}
+
+	mono, err := heap.FetchMono(addr)
+	if err != nil {
+		return 0, err
+	}
+	region := mono.region
+	h.Write([]byte{mono.kind})
+
+	switch mono.kind {
+	case MONO_INT32:
+		h.Write(region.content[mono.valueFromOffset : mono.valueFromOffset+4])
+	case MONO_FLOAT64:
+		f, err := region.ReadFloat64(mono.valueFromOffset)
+		if err != nil {
+			return 0, err
+		}
+		// -0 == 0, so they must hash equally.
+		if f == 0 {
+			f = 0
+		}
+		writeHashUint64(h, math.Float64bits(f))
+	case MONO_UINT16:
+		h.Write(region.content[mono.valueFromOffset : mono.valueFromOffset+2])
+	case MONO_INT64:
+		h.Write(region.content[mono.valueFromOffset : mono.valueFromOffset+8])
+	case MONO_BOOL:
+		h.Write(region.content[mono.valueFromOffset : mono.valueFromOffset+1])
+	case MONO_NULL, MONO_UNDEFINED:
+		// The kind is the value.
+	case MONO_ADDRESS:
+		// Hash what it points to.
+		pointer, err := region.ReadAddress(mono.valueFromOffset)
+		if err != nil {
+			return 0, err
+		}
+		path[addr] = len(path)
+		defer delete(path, addr)
+		hashTarget, err := heap.hashValue(pointer, path)
+		if err != nil {
+			return 0, err
+		}
+		writeHashUint64(h, hashTarget)
+	case MONO_STRING_S8:
+		// Bytes of all segments, not the addresses linking them.
+		ws, err := NewWrappedString(mono)
+		if err != nil {
+			return 0, err
+		}
+		bs, err := ws.ReadBytes()
+		if err != nil {
+			return 0, err
+		}
+		h.Write(bs)
+	case MONO_ARRAY_S8:
+		wa, err := NewWrappedArray(mono)
+		if err != nil {
+			return 0, err
+		}
+		length, err := wa.ReadLength()
+		if err != nil {
+			return 0, err
+		}
+		writeHashUint32(h, length)
+
+		path[addr] = len(path)
+		defer delete(path, addr)
+		for idx := uint32(0); idx < length; idx++ {
+			element, err := wa.Index(idx)
+			if err != nil {
+				return 0, err
+			}
+			hashElement, err := heap.hashValue(element.beginFrom, path)
+			if err != nil {
+				return 0, err
+			}
+			writeHashUint64(h, hashElement)
+		}
+	case MONO_OBJECT_S8:
+		wo, err := NewWrappedObject(mono)
+		if err != nil {
+			return 0, err
+		}
+		keys, err := wo.Keys()
+		if err != nil {
+			return 0, err
+		}
+		sort.Strings(keys)
+		writeHashUint32(h, uint32(len(keys)))
+
+		path[addr] = len(path)
+		defer delete(path, addr)
+		for _, key := range keys {
+			// The length first, so keys and values cannot run into each other.
+			writeHashUint32(h, uint32(len(key)))
+			h.Write([]byte(key))
+			value, err := wo.Get(key)
+			if err != nil {
+				return 0, err
+			}
+			hashValue, err := heap.hashValue(value.beginFrom, path)
+			if err != nil {
+				return 0, err
+			}
+			writeHashUint64(h, hashValue)
+		}
+	default:
+		return 0, errors.New(fmt.Sprintf(ErrorMessageCannotHashKind, mono.kind))
+	}
+	return h.Sum64(), nil
+}
+
+func writeHashUint32(h hash.Hash64, i uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], i)
+	h.Write(buf[:])
+}
+
+func writeHashUint64(h hash.Hash64, i uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], i)
+	h.Write(buf[:])
+}
@@ -0,0 +1,132 @@
+package heap
+
+import (
+	"testing"
+)
+
+func allocateTestInt32(tb testing.TB, heap *Heap, i int32) *Mono {
+	mono := allocateMono(tb, heap, MONO_INT32)
+	if err := mono.region.WriteInt32(mono.valueFromOffset, i); err != nil {
+		tb.Fatal(err)
+	}
+	return mono
+}
+
+func allocateTestFloat64(tb testing.TB, heap *Heap, f float64) *Mono {
+	mono := allocateMono(tb, heap, MONO_FLOAT64)
+	if err := mono.region.WriteFloat64(mono.valueFromOffset, f); err != nil {
+		tb.Fatal(err)
+	}
+	return mono
+}
+
+func allocateTestArray(tb testing.TB, heap *Heap, elements ...*Mono) *WrappedArray {
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, element := range elements {
+		if err := wa.Append(element); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return wa
+}
+
+// [1, 2.5, [n, n + 1, ..., n + 9]]
+func allocateTestNested(tb testing.TB, heap *Heap, n int32) *WrappedArray {
+	inner := allocateTestArray(tb, heap)
+	for i := int32(0); i < 10; i++ {
+		if err := inner.Append(allocateTestInt32(tb, heap, n+i)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return allocateTestArray(tb, heap,
+		allocateTestInt32(tb, heap, 1),
+		allocateTestFloat64(tb, heap, 2.5),
+		inner.mono,
+	)
+}
+
+func TestHashValue(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+
+	hashOf := func(wa *WrappedArray) uint64 {
+		hash, err := heap.HashValue(wa.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	foo := allocateTestNested(t, heap, 3)
+	bar := allocateTestNested(t, heap, 3)
+	baz := allocateTestNested(t, heap, 4)
+	if foo.mono.beginFrom == bar.mono.beginFrom {
+		t.Fatal("Expect values at different addresses")
+	}
+	if hashOf(foo) != hashOf(bar) {
+		t.Errorf("Equal values hash differently: %d vs. %d", hashOf(foo), hashOf(bar))
+	}
+	if hashOf(foo) == hashOf(baz) {
+		t.Errorf("Different values hash equally: %d", hashOf(foo))
+	}
+
+	// Arrays contain themselves.
+	cycleFoo := allocateTestArray(t, heap, allocateTestInt32(t, heap, 1))
+	cycleBar := allocateTestArray(t, heap, allocateTestInt32(t, heap, 1))
+	if err := cycleFoo.Append(cycleFoo.mono); err != nil {
+		t.Fatal(err)
+	}
+	if err := cycleBar.Append(cycleBar.mono); err != nil {
+		t.Fatal(err)
+	}
+	if hashOf(cycleFoo) != hashOf(cycleBar) {
+		t.Errorf("Equal cycles hash differently: %d vs. %d", hashOf(cycleFoo), hashOf(cycleBar))
+	}
+}
+
+func TestHashObjects(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+
+	// {foo: [n, ...], bar: [n, ...]}, with properties set in the given order.
+	allocateObject := func(n int32, names ...string) *WrappedObject {
+		wo := allocateTestObject(t, heap)
+		for _, name := range names {
+			if err := wo.Set(name, allocateTestNested(t, heap, n).mono); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return wo
+	}
+	hashOf := func(wo *WrappedObject) uint64 {
+		hash, err := heap.HashValue(wo.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	foo := allocateObject(3, "foo", "bar")
+	if hashOf(foo) != hashOf(allocateObject(3, "foo", "bar")) {
+		t.Errorf("Equal objects hash differently")
+	}
+	if hashOf(foo) != hashOf(allocateObject(3, "bar", "foo")) {
+		t.Errorf("Objects with equal properties in different orders hash differently")
+	}
+	if hashOf(foo) == hashOf(allocateObject(4, "foo", "bar")) {
+		t.Errorf("Objects differing in a nested property hash equally")
+	}
+	if hashOf(foo) == hashOf(allocateObject(3, "foo", "baz")) {
+		t.Errorf("Objects with different keys hash equally")
+	}
+
+	// An object contains itself.
+	cycle := allocateObject(1, "foo")
+	if err := cycle.Set("self", cycle.mono); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := heap.HashValue(cycle.mono.beginFrom); err != nil {
+		t.Errorf("Cannot hash an object containing itself: %v", err)
+	}
+}
@@ -1,10 +1,12 @@
 package heap
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"sync"
+	"unicode/utf16"
 )
 
 // Heap has regions.
@@ -12,12 +14,15 @@ import (
 // Allocator will try to re-use regions it keeps.
 // If there is no enough empty regions, it will ask Heap for more.
 // Heap may trigger a minor GC before it gives a new region out.
-// If minor GC is not enough, a full GC will be triggered.
+// If minor GC is not enough, a full GC, Compact, will be triggered.
 // The worst case is memory leaks and no region available anymore.
 // Then the Heap will throw a OOM (program crashed)
 
 const REGION_SIZE = 1024000 // Uint8 * 1024000 = 1MB
 const NUMBER_REGIONS = 256
+const MAX_NUMBER_REGIONS = 4096 // How many regions the heap can have after growing.
+const MAX_DEPTH = 10000         // How deep recursive walks like ToJSON go into nested values.
+const MAX_HEAP_BYTES = 1 << 32  // Pointer slots are uint32, so they cannot address a larger heap.
 
 const REGION_EDEN = 11
 const REGION_SURVIVOR = 12
@@ -32,31 +37,141 @@ const MONO_CHUNK_S8 = 31
 const MONO_STRING_S8 = 4
 const MONO_OBJECT_S8 = 5
 const MONO_NAMED_PROPERTY_S8 = 6 // (addressToStringMono, addressToMono) * 8
+const MONO_BOOL = 7
+const MONO_NULL = 8      // Header only; there is only one null.
+const MONO_UNDEFINED = 9 // Header only; there is only one undefined.
+const MONO_INT64 = 10
+const MONO_UINT16 = 12
+const MONO_FORWARD = 13 // Left by GC where a mono is moved from; see Mono.WriteForward.
+
+// Each mono begins with a header: [ #0 ] is its kind, and [ #1 ] is its flags.
+const MONO_HEADER_SIZE = 2
+
+// The high bits of the flags byte are the age of the mono, how many minor GCs it has
+// survived in Survivor regions; see Mono.ReadAge. Flags of Mono.SetFlag are the low bits.
+const MONO_AGE_SHIFT = 4
+const MONO_MAX_AGE = 1<<(8-MONO_AGE_SHIFT) - 1
 
 const MONO_CHUNK_SIZE = 8 // 8 elements per chunk.
 
+const TENURE_THRESHOLD = 2 // Monos surviving more minor GCs than this are promoted to Tenured.
+
 type address = uint64
+
+// Offsets are unsigned, so they never go below 0, but computing one may wrap
+// around instead, like `0 - 4` becomes a huge offset. Checks on offsets must be about
+// the range a computed offset should be in, not about being negative.
 type offset = uint32
 
 var ErrorMessageOffsetUnderflow = "Address to offset underflow: %d - %d"
 var ErrorMessageOffsetOutOfRange = "Offset out of the range: %d vs. %d"
-var ErrorMessageUnknownKind = "Unknown kind: %d"
+var ErrorMessageUnknownKind = "%w: %d"
 var ErrorMessageHeapFull = "Heap is full (need GC)"
+var ErrorMessageAddressOutOfRegions = "Address out of Region range: #%v"
+var ErrorMessageCannotReserve = "Cannot reserve %d monos of kind %d: only %d can fit"
+var ErrorMessageHeapGrowOverMax = "Cannot grow the heap over max regions: %d + %d > %d"
+var ErrorMessageHeapOverAddressable = "Heap of %d regions of %d bytes is over %d bytes pointers can address"
 var ErrorMessageChunkFull = "Chunk is full"
-var ErrorMessageRegionFull = "Region is full: cannot allocate %d bytes"
+var ErrorMessageRegionFull = "%w: cannot allocate %d bytes"
 var ErrorMessageCannotReadChunkLength = "Cannot read chunk length"
 var ErrorMessageCannotReadRegionOffset = "Cannot read by region offset: %d"
-var ErrorMessageIndexOutOfRange = "Index out of range: #%d vs. #%d"
+var ErrorMessageIndexOutOfRange = "%w: #%d vs. #%d"
 var ErrorMessageIndexedChunkOutOfRange = "The target chunk of index #%d is out of range"
+var ErrorMessageMonoOutOfRegion = "Mono of kind %d is out of the region: [%d, %d) vs. size %d"
+var ErrorMessageNotMonoBoundary = "Region offset is not at a mono header: %d"
+var ErrorMessageTooDeep = "Value is nested deeper than %d"
+
+// Errors callers may handle, like GC escalating when the heap is full.
+// Match them by errors.Is, since errors with more context wrap them,
+// like by fmt.Errorf(ErrorMessageRegionFull, ErrRegionFull, size).
+var ErrHeapFull = errors.New(ErrorMessageHeapFull)
+var ErrRegionFull = errors.New("Region is full")
+var ErrIndexOutOfRange = errors.New("Index out of range")
+var ErrUnknownKind = errors.New("Unknown kind")
 
 // Heap is used to allocate memories
 // to store data used by guest languages
+//
+// Allocating, taking new regions and growing the heap are safe from goroutines,
+// since they hold the heap lock. GC holds it as well, and anything else changing
+// region counters, content blocks or side tables shared by the heap, like interned
+// strings and the remembered set, must hold it. Reading and writing monos are not
+// guarded: a mono must not be written by goroutines at the same time, or while a GC runs.
 type Heap struct {
+	// Guards content blocks, region counters, the allocator and side tables.
+	// Methods with the Locked suffix must be called with it held.
+	mu sync.Mutex
+
 	content        [][]byte
 	contentCounter uint64
+
+	// How large each content block (region) is.
+	regionSize uint32
+
+	// How many content blocks the heap can have at most, after growing.
+	maxRegions int
+
+	// See HeapConfig.TenureThreshold.
+	tenureThreshold uint8
+
+	// See HeapConfig.MaxDepth.
+	maxDepth int
+
+	allocator *Allocator
+
+	// Side table of allocation-site tags, by mono address.
+	// Only monos allocated with a site are in it.
+	sites map[address]uint32
+
+	// Debug messages of the heap go here. Default: nothing is logged.
+	logger Logger
+
+	// Interned strings, by their Go string. See Heap.Intern.
+	// A GC moving string monos must update the addresses here.
+	interned map[string]address
+
+	// Heap addresses of pointer slots in old regions which point to young monos.
+	// See Region.WriteAddressBarrier.
+	remembered map[address]bool
+
+	// The Tenured region minor GC promotes monos to, until it is full.
+	promotion *Region
+
+	// Called after each GC. See Heap.OnGC.
+	onGC func(GCStats)
+
+	// Fraction of the capacity used bytes exceed to run automatic GC, and the roots
+	// it starts from. See Heap.SetGCThreshold.
+	gcThreshold float64
+	roots       func() []address
+
+	// If addresses with the top bit set are tagged ints. See Heap.EnableTaggedInts.
+	taggedInts bool
+
+	// Used bytes the last automatic GC couldn't go under the threshold from,
+	// plus a region. No automatic GC runs again until the used bytes exceed it.
+	gcFloor uint64
+}
+
+// Where the heap logs debug messages, like each mono visited when traversing regions.
+// The *log.Logger of the standard library is one.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Printf(format string, v ...interface{}) {}
+
+// Log debug messages to the `logger`, or to nowhere if it is nil.
+func (heap *Heap) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = noopLogger{}
+	}
+	heap.logger = logger
 }
 
-// Regions are now fixed as 1MB by a const REGION_SIZE.
+// Regions are 1MB by a const REGION_SIZE, unless the heap is created by NewHeapWithConfig.
 // Each region contains byte array with length
 // Our GC only cares about regions, and it keeps their information in a preserved area
 type Region struct {
@@ -83,6 +198,9 @@ type Region struct {
 	// Flag of what kind of this region is.
 	// Like, an Eden, or a humogous region.
 	kind byte
+
+	// How multi-byte numbers are read and written. LittleEndian by default.
+	byteOrder binary.ByteOrder
 }
 
 // Mono is a thing composes of bytes, correspond to one thing the guest language
@@ -115,7 +233,7 @@ type Region struct {
 type Mono struct {
 	region *Region
 
-	// 1 byte flag
+	// The first byte of the header. The second one is flags, see Mono.GetFlag.
 	kind byte
 
 	// **Heap address** where this Mono begins from.
@@ -124,7 +242,7 @@ type Mono struct {
 	// **Heap address** where this Mono ends at.
 	endAt address
 
-	// beginFrom +  1 byte; where to start to read the value.
+	// beginFrom + MONO_HEADER_SIZE; where to start to read the value.
 	valueFrom address
 
 	// **region offset**: how many bytes far from the beginning of the regions.
@@ -136,22 +254,208 @@ type Mono struct {
 	valueFromOffset offset
 }
 
+// The mono kind, like MONO_ARRAY_S8, for a caller outside the heap
+// to dispatch the mono to its wrapper.
+func (mono *Mono) Kind() byte {
+	return mono.kind
+}
+
+// Name of the mono kind, like "Array" for MONO_ARRAY_S8, for debugging output.
+func (mono *Mono) KindName() string {
+	return MonoKindName(mono.kind)
+}
+
+var monoKindNames = map[byte]string{
+	MONO_INT32:             "Int32",
+	MONO_ADDRESS:           "Address",
+	MONO_FLOAT64:           "Float64",
+	MONO_ARRAY_S8:          "Array",
+	MONO_CHUNK_S8:          "Chunk",
+	MONO_STRING_S8:         "String",
+	MONO_OBJECT_S8:         "Object",
+	MONO_NAMED_PROPERTY_S8: "NamedProperty",
+	MONO_BOOL:              "Bool",
+	MONO_NULL:              "Null",
+	MONO_UNDEFINED:         "Undefined",
+	MONO_INT64:             "Int64",
+	MONO_UINT16:            "Uint16",
+	MONO_FORWARD:           "Forward",
+}
+
+// Like Mono.KindName, but by the kind. An unknown kind is named by its number.
+func MonoKindName(kind byte) string {
+	if name, ok := monoKindNames[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%d)", kind)
+}
+
+// Heap address of the mono header. It is what pointers to the mono are.
+func (mono *Mono) BeginFrom() address {
+	return mono.beginFrom
+}
+
+// Heap address of the first byte after the mono.
+func (mono *Mono) EndAt() address {
+	return mono.endAt
+}
+
+// How many bytes the mono takes, including the header.
+func (mono *Mono) Size() uint32 {
+	return mono.endOffset - mono.beginOffset
+}
+
+// If the two are the same mono on the same heap, like `===` on objects in JavaScript.
+// Monos fetched separately are different Go values even at the same address,
+// so compare them by this instead of `==`. A nil mono only equals nil.
+func (mono *Mono) Equals(other *Mono) bool {
+	if mono == nil || other == nil {
+		return mono == other
+	}
+	return mono.beginFrom == other.beginFrom && mono.region.heap == other.region.heap
+}
+
 type Allocator struct {
 	heap    *Heap
 	regions []*Region
+
+	// Regions emptied by GC and reset, to be reused before taking new content blocks.
+	free []*Region
+
+	// Free lists of each region, by the address the region begins from: bytes of freed
+	// monos by their sizes, to be reused before bumping a region counter. See Heap.Free.
+	holes map[uint64]map[uint32][]hole
+
+	// Take the first hole large enough by address, instead of by size classes.
+	// Only to compare them, like in BenchmarkFreeChurn.
+	firstFit bool
+
+	// Chooses the region to allocate into. nil means BumpSelector.
+	selector RegionSelector
+}
+
+// Sizes of the heap. Zero fields mean to use the default constants.
+type HeapConfig struct {
+	// How large each region is. Default: REGION_SIZE.
+	RegionSize uint32
+
+	// How many regions are pre-allocated. Default: NUMBER_REGIONS.
+	NumberRegions int
+
+	// How many regions the heap can have after growing. Default: MAX_NUMBER_REGIONS.
+	MaxRegions int
+
+	// How many minor GCs a mono survives in Survivor regions before it is
+	// promoted to a Tenured region. Default: TENURE_THRESHOLD. At most MONO_MAX_AGE,
+	// since ages are in the mono header; a larger one is taken as MONO_MAX_AGE.
+	TenureThreshold uint8
+
+	// How deep HashValue, ToJSON and FromJSON go into nested values before they
+	// fail, instead of growing the Go stack without a bound. Default: MAX_DEPTH.
+	MaxDepth int
 }
 
 // Our "memory" the where whole guest language lives in.
 func NewHeap() *Heap {
+	return NewHeapWithConfig(HeapConfig{})
+}
+
+// Like NewHeap, but with sizes from the config. For example, a heap with
+// small regions makes tests of region-crossing and GC much quicker.
+//
+// It panics if the pre-allocated regions are over MAX_HEAP_BYTES, since pointers
+// to monos past it would wrap around to other monos. Check the config first if
+// it is from users.
+func NewHeapWithConfig(cfg HeapConfig) *Heap {
+	cfg = cfg.withDefaults()
+	if err := cfg.Check(); err != nil {
+		panic(err)
+	}
+
 	// Pre-allocated all regions.
 	content := make([][]byte, 0)
-	for i := 0; i < NUMBER_REGIONS; i++ {
-		content = append(content, make([]byte, REGION_SIZE))
+	for i := 0; i < cfg.NumberRegions; i++ {
+		content = append(content, make([]byte, cfg.RegionSize))
+	}
+	heap := &Heap{
+		content:         content,
+		contentCounter:  0,
+		regionSize:      cfg.RegionSize,
+		maxRegions:      cfg.MaxRegions,
+		tenureThreshold: cfg.TenureThreshold,
+		maxDepth:        cfg.MaxDepth,
+		logger:          noopLogger{},
+	}
+	heap.allocator = &Allocator{heap: heap}
+	return heap
+}
+
+// Error if the pre-allocated regions of the config are over MAX_HEAP_BYTES.
+// Growing over it fails by Grow instead, so MaxRegions may be larger.
+func (cfg HeapConfig) Check() error {
+	cfg = cfg.withDefaults()
+	if !addressable(cfg.NumberRegions, cfg.RegionSize) {
+		return errors.New(fmt.Sprintf(ErrorMessageHeapOverAddressable, cfg.NumberRegions, cfg.RegionSize, uint64(MAX_HEAP_BYTES)))
+	}
+	return nil
+}
+
+// If pointer slots can address all bytes of the regions.
+func addressable(regions int, regionSize uint32) bool {
+	return uint64(regions)*uint64(regionSize) <= MAX_HEAP_BYTES
+}
+
+func (cfg HeapConfig) withDefaults() HeapConfig {
+	if cfg.RegionSize == 0 {
+		cfg.RegionSize = REGION_SIZE
+	}
+	if cfg.NumberRegions == 0 {
+		cfg.NumberRegions = NUMBER_REGIONS
+	}
+	if cfg.MaxRegions == 0 {
+		cfg.MaxRegions = MAX_NUMBER_REGIONS
+	}
+	if cfg.TenureThreshold == 0 {
+		cfg.TenureThreshold = TENURE_THRESHOLD
+	}
+	if cfg.TenureThreshold > MONO_MAX_AGE {
+		cfg.TenureThreshold = MONO_MAX_AGE
+	}
+	if cfg.MaxDepth == 0 {
+		cfg.MaxDepth = MAX_DEPTH
+	}
+	return cfg
+}
+
+// Grow the heap with `extra` more content blocks, so NewRegion can succeed
+// again after the pre-allocated blocks are all used.
+//
+// New blocks are appended after the existing ones, so a block at index #i
+// still begins from `i * regionSize` as the pre-allocated ones do.
+// It fails if the heap would be over MAX_HEAP_BYTES, even under the max regions.
+func (heap *Heap) Grow(extra int) error {
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+	return heap.growLocked(extra)
+}
+
+func (heap *Heap) growLocked(extra int) error {
+	if len(heap.content)+extra > heap.maxRegions {
+		return errors.New(
+			fmt.Sprintf(ErrorMessageHeapGrowOverMax, len(heap.content), extra, heap.maxRegions))
+	}
+	if !addressable(len(heap.content)+extra, heap.regionSize) {
+		return errors.New(
+			fmt.Sprintf(ErrorMessageHeapOverAddressable, len(heap.content)+extra, heap.regionSize, uint64(MAX_HEAP_BYTES)))
 	}
-	return &Heap{
-		content:        content,
-		contentCounter: 0,
+	if heap.taggedInts && !fitsTaggedInts(len(heap.content)+extra, heap.regionSize) {
+		return errors.New(
+			fmt.Sprintf(ErrorMessageCannotTagInts, uint64(TAG_INT), len(heap.content)+extra, heap.regionSize))
 	}
+	for i := 0; i < extra; i++ {
+		heap.content = append(heap.content, make([]byte, heap.regionSize))
+	}
+	return nil
 }
 
 // On the heap, form a Region from a content block.
@@ -162,17 +466,25 @@ func NewHeap() *Heap {
 // how to read/write to its content memory.
 //
 func (heap *Heap) RegionFromContent(beginFrom uint64, size uint32, content []byte) *Region {
+	return heap.RegionFromContentWithOrder(beginFrom, size, content, binary.LittleEndian)
+}
+
+// Like RegionFromContent, but numbers in the content are in the byte order,
+// like a heap image produced on a big-endian system.
+func (heap *Heap) RegionFromContentWithOrder(beginFrom uint64, size uint32, content []byte, byteOrder binary.ByteOrder) *Region {
 	region := &Region{
 		heap:      heap,
 		size:      size,
 		beginFrom: beginFrom,
-		endAt:     beginFrom + REGION_SIZE - 1,
+		endAt:     beginFrom + uint64(size) - 1,
 
 		// To link the content already allocated.
 		content: content,
 
 		// Default kind is Eden.
 		kind: 0,
+
+		byteOrder: byteOrder,
 	}
 
 	// Since it is formed from a content, we read region data stored in the content block.
@@ -181,53 +493,85 @@ func (heap *Heap) RegionFromContent(beginFrom uint64, size uint32, content []byt
 	return region
 }
 
+// How many content blocks are handed out as regions, by NewRegion
+// or by the allocator, including regions reset by GC to be reused.
+func (heap *Heap) RegionCount() int {
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+	return int(heap.contentCounter)
+}
+
+// Call the callback with each region handed out, like RegionCount counts them,
+// with its index on the heap, in the order of their addresses, like for a tool
+// rendering how full each region is. Stop at the first error the callback returns,
+// and return it.
+//
+// The heap lock is not held while the callback runs, so it can allocate, but regions
+// taken by the allocation may not be visited.
+func (heap *Heap) EachRegion(cb func(index int, region *Region) error) error {
+	count := heap.RegionCount()
+	for i := 0; i < count; i++ {
+		region := heap.RegionFromContent(uint64(i)*uint64(heap.regionSize), heap.regionSize, heap.content[i])
+		if err := cb(i, region); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // On the heap, create a totally new Region with the last unoccupied content block.
 func (heap *Heap) NewRegion() (*Region, error) {
-	// The last unoccupied content block.
-	content := heap.content[heap.contentCounter]
-	beginFrom := heap.contentCounter * REGION_SIZE
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+	return heap.newRegionLocked()
+}
 
-	if heap.contentCounter+1 > NUMBER_REGIONS {
-		return nil, errors.New(fmt.Sprint(ErrorMessageHeapFull))
+func (heap *Heap) newRegionLocked() (*Region, error) {
+	if heap.contentCounter+1 > uint64(len(heap.content)) {
+		return nil, ErrHeapFull
 	}
 
-	return &Region{
-		heap:      heap,
-		size:      REGION_SIZE,
-		beginFrom: beginFrom,
-		endAt:     beginFrom + REGION_SIZE - 1,
-
-		// To link the content already allocated.
-		content: content,
+	// The last unoccupied content block.
+	content := heap.content[heap.contentCounter]
+	beginFrom := heap.contentCounter * uint64(heap.regionSize)
 
-		// Default kind is Eden.
-		kind: 0,
-	}, nil
+	// Form it as a region, so its counter and kind bytes are initialized.
+	region := heap.RegionFromContent(beginFrom, heap.regionSize, content)
+	heap.contentCounter += 1
+	return region, nil
 }
 
-// Fetch a mono from the heap by address, not from a region by an offset.
-// The address must point to the header byte of the Mono.
-func (heap *Heap) FetchMono(address address) (*Mono, error) {
+// Form the region where the address is, and return the offset of the address in it.
+// Error if the address is not in a region in use.
+//
+// Content blocks from the content counter on are not regions yet.
+// Forming a region from one would read a block NewRegion may hand out later.
+func (heap *Heap) RegionOf(addr address) (*Region, offset, error) {
 	// This address is at which content block on the heap.
-	contentIndex := (address / REGION_SIZE >> 0)
-	if contentIndex > NUMBER_REGIONS {
-		return nil, errors.New(fmt.Sprintf("Address out of Region range: #%v", address))
+	contentIndex := addr / uint64(heap.regionSize)
+	if contentIndex >= heap.contentCounter {
+		return nil, 0, errors.New(fmt.Sprintf(ErrorMessageAddressOutOfRegions, addr))
 	}
 
-	// Find the headless content block.
-	//
+	// At which content (ex: #19 begin from #0) * regionSize = address of the region header.
+	regionBeginFrom := contentIndex * uint64(heap.regionSize)
+
 	// Content is just bunch of memory and thus we cannot use Region's methods
 	// before we form/create the Region for it.
-	contentBlock := heap.content[contentIndex]
-
-	// At which region offset the Mono begins from
-	monoOffset := offset(address % REGION_SIZE)
-
-	// At which content (ex: #19 begin from #0) * REGION_SIZE = address of the region header.
-	regionBeginFrom := contentIndex * REGION_SIZE
+	region := heap.RegionFromContent(regionBeginFrom, heap.regionSize, heap.content[contentIndex])
+	return region, offset(addr - regionBeginFrom), nil
+}
 
-	// From the target content, form the Region, so we can use region methods.
-	region := heap.RegionFromContent(regionBeginFrom, REGION_SIZE, contentBlock)
+// Fetch a mono from the heap by address, not from a region by an offset.
+// The address must point to the first header byte of the Mono.
+func (heap *Heap) FetchMono(address address) (*Mono, error) {
+	if heap.isTaggedInt(address) {
+		return nil, fmt.Errorf(ErrorMessageTaggedInt, ErrTaggedInt, address)
+	}
+	region, monoOffset, err := heap.RegionOf(address)
+	if err != nil {
+		return nil, err
+	}
 	monoKind, err := region.ReadByte(monoOffset)
 	if err != nil {
 		return nil, err
@@ -280,21 +624,53 @@ func (region *Region) ReadCounter() error {
 // Write the #4 byte for the assigned kind.
 func (region *Region) WriteKind(kind byte) error {
 	switch kind {
-	case REGION_EDEN:
-		region.WriteByte(4, kind)
-		return nil
-	case REGION_SURVIVOR:
-		region.WriteByte(4, kind)
-		return nil
-	case REGION_TENURED:
-		region.WriteByte(4, kind)
-		return nil
-	case REGION_HUMOGOUS:
-		region.WriteByte(4, kind)
-		return nil
+	case REGION_EDEN, REGION_SURVIVOR, REGION_TENURED, REGION_HUMOGOUS:
+		region.kind = kind
+		return region.WriteByte(4, kind)
 	default:
-		return errors.New(fmt.Sprintf(ErrorMessageUnknownKind, kind))
+		return fmt.Errorf(ErrorMessageUnknownKind, ErrUnknownKind, kind)
+	}
+}
+
+// Copy the region and its content bytes, like a snapshot before speculative
+// allocations. The clone doesn't share content with the region, and it is not
+// on the heap: addresses still point to the region, not to the clone.
+func (region *Region) Clone() *Region {
+	clone := *region
+	clone.content = make([]byte, len(region.content))
+	copy(clone.content, region.content)
+	return &clone
+}
+
+// Zero the bytes in [from, to), like the bytes of a mono freed, or a pointer slot vacated.
+//
+// Bytes no mono takes anymore must be zeroed before the next GC or traversal:
+// a kind byte left there reads as a mono header, and a pointer left in a slot
+// keeps its target alive, or is followed to where the target is not anymore.
+func (region *Region) Zero(from, to offset) error {
+	if from > to || !region.inRange(from, to-from) {
+		return errors.New(fmt.Sprintf(ErrorMessageOffsetOutOfRange, to, region.size))
+	}
+	for at := from; at < to; at++ {
+		region.content[at] = 0
+	}
+	return nil
+}
+
+// Empty the region, so it is like a new Eden region: zero the bytes after
+// the header, reset the counter to 5, and the kind to Eden.
+//
+// Monos in the region are gone, so it is only for a region nothing points
+// to anymore, like one GC has copied all live monos out of.
+func (region *Region) Reset() error {
+	if err := region.Zero(5, region.size); err != nil {
+		return err
+	}
+	region.counter = 5
+	if err := region.WriteCounter(); err != nil {
+		return err
 	}
+	return region.WriteKind(REGION_EDEN)
 }
 
 // Write the #0 byte for the region kind (uint32, needs 4 bytes)
@@ -306,7 +682,7 @@ func (region *Region) WriteCounter() error {
 // Heap address need to be translated before being used here (by `address - region.beginFrom`)
 
 // And all these write is for host value, while read is also to host value.
-// By default reads and writes are in LittleEdian.
+// Reads and writes are in the region's byte order, which is LittleEndian by default.
 
 // Why there are so many names of type like Uint8, Uint32, etc., is because although
 // we can determinate the host language type (Go's uint8, uint32, etc.),
@@ -314,8 +690,14 @@ func (region *Region) WriteCounter() error {
 // guest types. So for example, `ReadUint8` means guest language implementation's `uint8`,
 // not really for Go's.
 
+// If `width` bytes from `at` are all in the region.
+// It doesn't compute `at + width`, which may wrap around and pass the check.
+func (region *Region) inRange(at offset, width uint32) bool {
+	return at <= region.size && width <= region.size-at
+}
+
 func (region *Region) ReadUint8(at offset) (uint8, error) {
-	if at > region.size || at < 0 {
+	if !region.inRange(at, 1) {
 		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
 
@@ -327,30 +709,42 @@ func (region *Region) ReadByte(at offset) (byte, error) {
 	return region.ReadUint8(at)
 }
 
+func (region *Region) ReadUint16(at offset) (uint16, error) {
+	if !region.inRange(at, 2) {
+		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
+	}
+
+	// Read from the `at`.
+	return region.byteOrder.Uint16(region.content[at:]), nil
+}
+
 func (region *Region) ReadUint32(at offset) (uint32, error) {
-	if at > region.size || at < 0 {
+	if !region.inRange(at, 4) {
 		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
 
 	// Read from the `at`.
-	return binary.LittleEndian.Uint32(region.content[at:]), nil
+	return region.byteOrder.Uint32(region.content[at:]), nil
 }
 
 func (region *Region) ReadUint64(at offset) (uint64, error) {
-	if at > region.size || at < 0 {
+	if !region.inRange(at, 8) {
 		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
 
 	// Read from the `at`.
-	return binary.LittleEndian.Uint64(region.content[at:]), nil
+	return region.byteOrder.Uint64(region.content[at:]), nil
 }
 
+// Pointers on the heap are 32bits, so they fit in the 4 bytes slots
+// of monos like chunks. The host side still uses `address` (uint64).
 func (region *Region) ReadAddress(at offset) (address, error) {
-	return region.ReadUint64(at)
+	pointer, err := region.ReadUint32(at)
+	return address(pointer), err
 }
 
 func (region *Region) ReadInt8(at offset) (int8, error) {
-	if at > region.size || at < 0 {
+	if !region.inRange(at, 1) {
 		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
 
@@ -358,46 +752,44 @@ func (region *Region) ReadInt8(at offset) (int8, error) {
 }
 
 func (region *Region) ReadInt32(at offset) (int32, error) {
-	if at > region.size || at < 0 {
+	if !region.inRange(at, 4) {
 		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
 
 	// Read from the `at`.
-	return int32(binary.LittleEndian.Uint32(region.content[at:])), nil
+	return int32(region.byteOrder.Uint32(region.content[at:])), nil
 }
 
-func (region *Region) ReadFloat32(at offset) (float32, error) {
-	if at > region.size || at+4 > region.size || at < 0 {
+func (region *Region) ReadInt64(at offset) (int64, error) {
+	if !region.inRange(at, 8) {
 		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
 
-	// Read from the `at` then convert to Float32
-	var result float32
-	buf := bytes.NewReader(region.content[at:])
-	err := binary.Read(buf, binary.LittleEndian, &result)
-	if err != nil {
-		return 0, err
+	// Read from the `at`.
+	return int64(region.byteOrder.Uint64(region.content[at:])), nil
+}
+
+func (region *Region) ReadFloat32(at offset) (float32, error) {
+	if !region.inRange(at, 4) {
+		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
-	return result, err
+
+	// Convert the bits as they are, like WriteFloat32, so NaN payloads are kept.
+	return math.Float32frombits(region.byteOrder.Uint32(region.content[at:])), nil
 }
 
 func (region *Region) ReadFloat64(at offset) (float64, error) {
-	if at > region.size || at+8 > region.size || at < 0 {
+	if !region.inRange(at, 8) {
 		return 0, errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
 	}
 
-	// Read from the `at` then convert to Float64
-	var result float64
-	buf := bytes.NewReader(region.content[at:])
-	err := binary.Read(buf, binary.LittleEndian, &result)
-	if err != nil {
-		return 0, err
-	}
-	return result, err
+	// Convert the bits as they are, like WriteFloat64, so -0, subnormals and
+	// NaN payloads, including signaling NaNs, read back bit for bit.
+	return math.Float64frombits(region.byteOrder.Uint64(region.content[at:])), nil
 }
 
 func (region *Region) WriteUint8(at offset, i uint8) error {
-	if at+1 > region.size || at < 0 {
+	if !region.inRange(at, 1) {
 		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
 
@@ -411,38 +803,41 @@ func (region *Region) WriteByte(at offset, i byte) error {
 	return region.WriteUint8(at, i)
 }
 
-func (region *Region) WriteUint32(at offset, i uint32) error {
-	if at+4 > region.size || at < 0 {
+func (region *Region) WriteUint16(at offset, i uint16) error {
+	if !region.inRange(at, 2) {
 		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
 
-	bytes.NewBuffer(region.content[at:])
-	err := binary.Write(bytes.NewBuffer(region.content[at:]), binary.LittleEndian, i)
-	if err != nil {
-		return err
+	region.byteOrder.PutUint16(region.content[at:], i)
+	return nil
+}
+
+func (region *Region) WriteUint32(at offset, i uint32) error {
+	if !region.inRange(at, 4) {
+		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
+
+	// Write into the content directly. A bytes.Buffer over the content
+	// would append after the slice, not overwrite it.
+	region.byteOrder.PutUint32(region.content[at:], i)
 	return nil
 }
 
 func (region *Region) WriteUint64(at offset, i uint64) error {
-	if at+4 > region.size || at < 0 {
+	if !region.inRange(at, 8) {
 		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
 
-	bytes.NewBuffer(region.content[at:])
-	err := binary.Write(bytes.NewBuffer(region.content[at:]), binary.LittleEndian, i)
-	if err != nil {
-		return err
-	}
+	region.byteOrder.PutUint64(region.content[at:], i)
 	return nil
 }
 
 func (region *Region) WriteAddress(at offset, address address) error {
-	return region.WriteUint64(at, address)
+	return region.WriteUint32(at, uint32(address))
 }
 
 func (region *Region) WriteInt8(at offset, i int8) error {
-	if at+1 > region.size || at < 0 {
+	if !region.inRange(at, 1) {
 		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
 
@@ -452,44 +847,59 @@ func (region *Region) WriteInt8(at offset, i int8) error {
 }
 
 func (region *Region) WriteInt32(at offset, i int32) error {
-	if at+4 > region.size || at < 0 {
+	if !region.inRange(at, 4) {
 		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
 
-	bytes.NewBuffer(region.content[at:])
-	err := binary.Write(bytes.NewBuffer(region.content[at:]), binary.LittleEndian, i)
-	if err != nil {
-		return err
+	region.byteOrder.PutUint32(region.content[at:], uint32(i))
+	return nil
+}
+
+func (region *Region) WriteInt64(at offset, i int64) error {
+	if !region.inRange(at, 8) {
+		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
+
+	region.byteOrder.PutUint64(region.content[at:], uint64(i))
 	return nil
 }
 
 func (region *Region) WriteFloat32(at offset, f float32) error {
-	if at+4 > region.size || at < 0 {
+	if !region.inRange(at, 4) {
 		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
 
-	bytes.NewBuffer(region.content[at:])
-	err := binary.Write(bytes.NewBuffer(region.content[at:]), binary.LittleEndian, f)
-	if err != nil {
-		return err
-	}
+	region.byteOrder.PutUint32(region.content[at:], math.Float32bits(f))
 	return nil
 }
 
 func (region *Region) WriteFloat64(at offset, f float64) error {
-	if at+8 > region.size || at < 0 {
+	if !region.inRange(at, 8) {
 		return errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
 	}
 
-	bytes.NewBuffer(region.content[at:])
-	err := binary.Write(bytes.NewBuffer(region.content[at:]), binary.LittleEndian, f)
-	if err != nil {
-		return err
-	}
+	region.byteOrder.PutUint64(region.content[at:], math.Float64bits(f))
 	return nil
 }
 
+// Copy bytes of the string from `at`, as they are, with no length or mono header.
+// Return the offset right after the last byte written.
+// Nothing is written if the whole string doesn't fit into the region.
+func (region *Region) WriteString(at offset, s string) (offset, error) {
+	if !region.inRange(at, uint32(len(s))) {
+		return 0, errors.New(fmt.Sprintf("Write at address out of range: %#v", at))
+	}
+	return at + uint32(copy(region.content[at:], s)), nil
+}
+
+// Read `n` bytes from `at` as a string, like written by WriteString.
+func (region *Region) ReadString(at offset, n uint32) (string, error) {
+	if !region.inRange(at, n) {
+		return "", errors.New(fmt.Sprintf("Read from address out of range: %#v", at))
+	}
+	return string(region.content[at : at+n]), nil
+}
+
 // New means the used-bytes counter will be increased, while Write won't since
 // it may be for updating, not newly create a value in the region.
 
@@ -505,6 +915,14 @@ func (region *Region) NewByte(at offset, bt byte) error {
 	return region.NewUint8(at, bt)
 }
 
+func (region *Region) NewUint16(at offset, i uint16) error {
+	if err := region.WriteUint16(at, i); err != nil {
+		return err
+	}
+	region.counter += 2
+	return nil
+}
+
 func (region *Region) NewUint32(at offset, i uint32) error {
 	if err := region.WriteUint32(at, i); err != nil {
 		return err
@@ -522,7 +940,7 @@ func (region *Region) NewUint64(at offset, i uint64) error {
 }
 
 func (region *Region) NewAddress(at offset, address address) error {
-	return region.NewUint64(at, address)
+	return region.NewUint32(at, uint32(address))
 }
 
 func (region *Region) NewInt8(at offset, i int8) error {
@@ -541,6 +959,14 @@ func (region *Region) NewInt32(at offset, i int32) error {
 	return nil
 }
 
+func (region *Region) NewInt64(at offset, i int64) error {
+	if err := region.WriteInt64(at, i); err != nil {
+		return err
+	}
+	region.counter += 8
+	return nil
+}
+
 func (region *Region) NewFloat32(at offset, f float32) error {
 	if err := region.WriteFloat32(at, f); err != nil {
 		return err
@@ -557,6 +983,41 @@ func (region *Region) NewFloat64(at offset, f float64) error {
 	return nil
 }
 
+// The region kind, like REGION_EDEN.
+func (region *Region) Kind() byte {
+	return region.kind
+}
+
+// Name of the region kind, like "Eden" for REGION_EDEN, for debugging output.
+func (region *Region) KindName() string {
+	return RegionKindName(region.kind)
+}
+
+// Like Region.KindName, but by the kind. An unknown kind is named by its number.
+func RegionKindName(kind byte) string {
+	switch kind {
+	case REGION_EDEN:
+		return "Eden"
+	case REGION_SURVIVOR:
+		return "Survivor"
+	case REGION_TENURED:
+		return "Tenured"
+	case REGION_HUMOGOUS:
+		return "Humongous"
+	}
+	return fmt.Sprintf("Unknown(%d)", kind)
+}
+
+// How many bytes monos take in the region, not counting the counter and kind bytes.
+func (region *Region) UsedBytes() uint32 {
+	return region.counter - 5
+}
+
+// How many bytes are left in the region for more monos.
+func (region *Region) FreeBytes() uint32 {
+	return region.size - region.counter
+}
+
 // If the region is still as empty as here requires.
 func (region *Region) capable(n uint32) bool {
 	if region.counter+n > region.size {
@@ -566,35 +1027,130 @@ func (region *Region) capable(n uint32) bool {
 }
 
 func (region *Region) traverse(cb func(*Mono) error) error {
-	for beginOffset := uint32(5); beginOffset < region.counter; {
-		fmt.Printf("Try to visit mono at: %d", beginOffset) // TODO: real logger.
+	return region.traverseFrom(5, cb)
+}
+
+// All monos in the region, in the order of their headers.
+func (region *Region) Monos() ([]*Mono, error) {
+	monos := []*Mono{}
+	err := region.traverse(func(mono *Mono) error {
+		monos = append(monos, mono)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return monos, nil
+}
+
+// Addresses of all monos in all regions in use, like candidates of GC roots
+// in tests and tools. They are not only live monos: there is no marking here.
+// The default chunk embedded in an array is not a mono by itself.
+func (heap *Heap) Addresses() ([]address, error) {
+	addresses := []address{}
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		region := heap.RegionFromContent(i*uint64(heap.regionSize), heap.regionSize, heap.content[i])
+		err := region.traverse(func(mono *Mono) error {
+			addresses = append(addresses, mono.beginFrom)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return addresses, nil
+}
+
+// Like traverse, but begin from the mono at the `start` offset, like to resume
+// an incremental scan. The `start` must be the header of a mono in the region.
+//
+// Only live monos are visited, until the region counter:
+// forwards left by GC are jumped over, and so are 0 bytes,
+// which are gaps no mono takes, like the rest of a mono after its forward.
+func (region *Region) traverseFrom(start offset, cb func(*Mono) error) error {
+	return region.traverseFromUntil(start, func(mono *Mono) (bool, error) {
+		return false, cb(mono)
+	})
+}
+
+// Like traverse, but stop once the callback returns true, like when searching for
+// the first mono of a kind, so the rest of a large region is not walked.
+func (region *Region) TraverseUntil(cb func(*Mono) (stop bool, err error)) error {
+	return region.traverseFromUntil(5, cb)
+}
+
+func (region *Region) traverseFromUntil(start offset, cb func(*Mono) (bool, error)) error {
+	// Nothing to visit from the counter on, like in an empty region.
+	if start == region.counter {
+		return nil
+	}
+	if err := region.validateMonoBoundary(start); err != nil {
+		return err
+	}
+	for beginOffset := start; beginOffset < region.counter; {
+		region.heap.logger.Printf("Try to visit mono at: %d", beginOffset)
 		kind, err := region.ReadByte(beginOffset)
 		if err != nil {
 			return err
 		}
 		if kind == 0 {
-			// End of monos. We traverse by jumping among Mono headers,
-			// if we got a 0 then this means unoccupied area which has no Mono yet.
-			break
+			// A gap. We traverse by jumping among Mono headers,
+			// if we got a 0 then this byte is not occupied by any Mono.
+			beginOffset++
+			continue
+		}
+		if kind == MONO_FORWARD {
+			size, _ := monoSizeFromKind(MONO_FORWARD)
+			beginOffset += size
+			continue
 		}
 		mono, err := region.NewMono(kind, beginOffset)
 		if err != nil {
 			return err
 		}
-		err = cb(mono)
-		if err != nil {
+		stop, err := cb(mono)
+		if err != nil || stop {
 			return err
 		}
-		beginOffset = mono.endOffset + 1
+		// Mono.endOffset is the first byte after the mono, namely the next mono's header.
+		beginOffset = mono.endOffset
 	}
 	return nil
 }
 
-// Form a Mono from the region offset.
+// Since monos are only known by jumping among headers from the first one,
+// walk from the first mono until reaching the `at` offset.
+func (region *Region) validateMonoBoundary(at offset) error {
+	for beginOffset := uint32(5); beginOffset < region.counter; {
+		if beginOffset == at {
+			return nil
+		}
+		if beginOffset > at {
+			break
+		}
+		kind, err := region.ReadByte(beginOffset)
+		if err != nil {
+			return err
+		}
+		// A gap, see traverseFrom.
+		if kind == 0 {
+			beginOffset++
+			continue
+		}
+		size, err := monoSizeFromKind(kind)
+		if err != nil {
+			return err
+		}
+		beginOffset += size
+	}
+	return errors.New(fmt.Sprintf(ErrorMessageNotMonoBoundary, at))
+}
+
+// Form a Mono from the region offset.
 // There is no complicated "creation" of Monos, since a mono is just a memory block in the region
-// with a header byte. The header is the only important thing to the mono and region.
+// with a header. The header is the only important thing to the mono and region.
 //
-// Therefore, to create a whole new Mono, the allocator just write the header byte at the address.
+// Therefore, to create a whole new Mono, the allocator just write the header at the address.
 func (region *Region) NewMono(kind byte, beginOffset offset) (*Mono, error) {
 	monoSize, err := monoSizeFromKind(kind)
 	if err != nil {
@@ -611,13 +1167,14 @@ func (region *Region) NewMono(kind byte, beginOffset offset) (*Mono, error) {
 	}
 
 	return &Mono{
-		region:      region,
-		kind:        kind,
-		beginOffset: beginOffset,
-		endOffset:   beginOffset + monoSize,
-		beginFrom:   beginFrom,
-		endAt:       beginFrom + uint64(monoSize),
-		valueFrom:   beginFrom + 1,
+		region:          region,
+		kind:            kind,
+		beginOffset:     beginOffset,
+		endOffset:       beginOffset + monoSize,
+		beginFrom:       beginFrom,
+		endAt:           beginFrom + uint64(monoSize),
+		valueFrom:       beginFrom + MONO_HEADER_SIZE,
+		valueFromOffset: beginOffset + MONO_HEADER_SIZE,
 	}, nil
 }
 
@@ -627,7 +1184,7 @@ func (region *Region) CreateMono(kind byte) (*Mono, error) {
 		return nil, err
 	}
 	if !region.capable(increase) {
-		return nil, errors.New(fmt.Sprintf(ErrorMessageRegionFull, increase))
+		return nil, fmt.Errorf(ErrorMessageRegionFull, ErrRegionFull, increase)
 	}
 	// From the last unoccupied byte of the region,
 	// new a Mono.
@@ -640,88 +1197,470 @@ func (region *Region) CreateMono(kind byte) (*Mono, error) {
 		return nil, err
 	}
 
-	region.counter = increase
+	region.counter += increase
+	err = region.WriteCounter()
+	if err != nil {
+		return nil, err
+	}
 	return mono, nil
 }
 
 func monoSizeFromKind(kind byte) (uint32, error) {
 	switch kind {
 	case MONO_INT32:
-		// 1 + 4 (header: 1 byte + int32)
-		return 5, nil
+		// 2 + 4 (header: 2 bytes + int32)
+		return 6, nil
 	case MONO_ADDRESS:
-		// 1 + 4 (header: 1 byte + int32)
-		return 5, nil
+		// 2 + 4 (header: 2 bytes + int32)
+		return 6, nil
 	case MONO_FLOAT64:
-		// 1 + 8
-		return 9, nil
+		// 2 + 8
+		return 10, nil
+	case MONO_INT64:
+		// 2 + 8 (header: 2 bytes + int64)
+		return 10, nil
+	case MONO_UINT16:
+		// 2 + 2 (header: 2 bytes + uint16)
+		return 4, nil
 	case MONO_ARRAY_S8:
-		// 1 + 4 + 1 + 1 + 4 * 8 + 4 (header + array length + init chunk header + init chunk length + 8 slots + address to next)
-		return 43, nil
+		// 2 + 4 + 2 + 1 + 4 * 8 + 4 (header + array length + init chunk header + init chunk length + 8 slots + address to next)
+		return 45, nil
 	case MONO_CHUNK_S8:
-		// 1 + 1 + 4 * 8 + 4 (header + chunk length + 8 slots + address to next)
-		return 38, nil
+		// 2 + 1 + 4 * 8 + 4 (header + chunk length + 8 slots + address to next)
+		return 39, nil
 	case MONO_STRING_S8:
-		// 1 + 8 * 8 + 4 (header + 8 slots + address to next)
-		return 69, nil
+		// 2 + 4 + 8 * 8 + 4 (header + length + 8 slots + address to next)
+		return 74, nil
 	case MONO_OBJECT_S8:
-		// 1 + 8 * 8  + 4 + 4 (header + 8 slots + address to name/address dict + address to next)
-		return 73, nil
+		// 2 + 8 * 8  + 4 + 4 (header + 8 slots + address to name/address dict + address to next)
+		return 74, nil
 	case MONO_NAMED_PROPERTY_S8:
-		// 1 + (4 + 4) * 8 + 4 (header + address pairs + address to next)
-		return 73, nil
+		// 2 + (4 + 4) * 8 + 4 (header + address pairs + address to next)
+		return 70, nil
+	case MONO_BOOL:
+		// 2 + 1 (header + 0 or 1)
+		return 3, nil
+	case MONO_NULL:
+		// 2 (header)
+		return 2, nil
+	case MONO_UNDEFINED:
+		// 2 (header)
+		return 2, nil
+	case MONO_FORWARD:
+		// 2 + 8 (header + address of the moved mono)
+		return 10, nil
 	default:
-		return 0, errors.New(fmt.Sprintf("Wrong Mono kind: #%v", kind))
+		return 0, fmt.Errorf(ErrorMessageUnknownKind, ErrUnknownKind, kind)
+	}
+}
+
+// If the mono is as large as its kind and inside its region.
+func (mono *Mono) validateInRegion() error {
+	size, err := monoSizeFromKind(mono.kind)
+	if err != nil {
+		return err
+	}
+	if mono.endOffset < mono.beginOffset ||
+		mono.endOffset-mono.beginOffset != size ||
+		mono.endOffset > mono.region.size {
+		return errors.New(fmt.Sprintf(ErrorMessageMonoOutOfRegion,
+			mono.kind, mono.beginOffset, mono.endOffset, mono.region.size))
 	}
+	return nil
 }
 
-// Write header information onto region content.
+// Write header information onto region content. A new header has no flag set.
 // REMEMBER TO CALL THIS for any newly created Mono.
 func (mono *Mono) WriteHeader() error {
-	return mono.region.WriteByte(mono.beginOffset, mono.kind)
+	if err := mono.region.WriteByte(mono.beginOffset, mono.kind); err != nil {
+		return err
+	}
+	return mono.region.WriteByte(mono.beginOffset+1, 0)
+}
+
+// If the flag is set in the header. Flags are the low bits of the second header byte,
+// from `1 << 0` to `1 << 3`, for GC and tools to mark monos in place, without a side table.
+// The high bits are the age of the mono, which flags must not touch.
+func (mono *Mono) GetFlag(flag byte) (bool, error) {
+	flags, err := mono.region.ReadByte(mono.beginOffset + 1)
+	if err != nil {
+		return false, err
+	}
+	return flags&flag != 0, nil
+}
+
+// Set or clear the flag in the header. Other flags are kept.
+func (mono *Mono) SetFlag(flag byte, on bool) error {
+	flags, err := mono.region.ReadByte(mono.beginOffset + 1)
+	if err != nil {
+		return err
+	}
+	if on {
+		flags |= flag
+	} else {
+		flags &^= flag
+	}
+	return mono.region.WriteByte(mono.beginOffset+1, flags)
 }
 
+// Allocate a mono of the kind, and wrap it by the constructor, for callers
+// which only know the kind at runtime. Typed constructors like Allocator.Array
+// call allocateMono instead, so there is no boxing and type assertion.
+//
+// It may run a GC before allocating. See Heap.SetGCThreshold.
 func (a *Allocator) Allocate(kind byte, wrappedConstructor func(*Mono) *interface{}) (*interface{}, error) {
-	latestRegion := a.latestRegion()
+	return a.AllocateWithSite(kind, SITE_NONE, wrappedConstructor)
+}
+
+// Like Allocate, but tag the new mono with an allocation site,
+// like the guest source location creates the value, for heap profiling.
+// See Heap.BytesBySite.
+func (a *Allocator) AllocateWithSite(kind byte, site uint32, wrappedConstructor func(*Mono) *interface{}) (*interface{}, error) {
+	mono, err := a.allocateMonoCollecting(kind, site)
+	if err != nil {
+		return nil, err
+	}
+	return wrappedConstructor(mono), nil
+}
+
+func (a *Allocator) allocateMono(kind byte) (*Mono, error) {
+	return a.allocateMonoWithSite(kind, SITE_NONE)
+}
+
+func (a *Allocator) allocateMonoWithSite(kind byte, site uint32) (*Mono, error) {
+	a.heap.mu.Lock()
+	defer a.heap.mu.Unlock()
+	return a.allocateMonoLocked(kind, site)
+}
+
+// Allocate the mono into a hole left by Heap.Free if one is large enough.
+// Otherwise, bump the counter of the region the selector chooses for the mono,
+// which is the latest region by default, or take a new region if there is none.
+func (a *Allocator) allocateMonoLocked(kind byte, site uint32) (*Mono, error) {
 	size, err := monoSizeFromKind(kind)
 	if err != nil {
 		return nil, err
 	}
-	// If it is not capable, create a new Region then allocate.
-	if !latestRegion.capable(size) {
-		latestRegion, err = a.heap.NewRegion()
-		if err != nil {
-			return nil, err
+	mono, err := a.allocateFromHoleLocked(kind, size)
+	if err != nil {
+		return nil, err
+	}
+	if mono != nil {
+		if site != SITE_NONE {
+			a.heap.tagSite(mono.beginFrom, site)
 		}
-		a.regions = append(a.regions, latestRegion)
+		return mono, nil
+	}
+	region, err := a.selectRegionLocked(size)
+	if err != nil {
+		return nil, err
+	}
+	mono, err = region.CreateMono(kind)
+	if err != nil {
+		return nil, err
+	}
+	if site != SITE_NONE {
+		a.heap.tagSite(mono.beginFrom, site)
 	}
-	mono, err := latestRegion.CreateMono(kind)
+	return mono, nil
+}
+
+// The region the selector chooses for `size` bytes. If no region is chosen,
+// like there is none yet, take a new region.
+func (a *Allocator) selectRegionLocked(size uint32) (*Region, error) {
+	var selector RegionSelector = BumpSelector{}
+	if a.selector != nil {
+		selector = a.selector
+	}
+	if region := selector.SelectRegion(a.regions, size); region != nil {
+		return region, nil
+	}
+	region, err := a.newRegionLocked()
 	if err != nil {
 		return nil, err
 	}
+	a.regions = append(a.regions, region)
+	return region, nil
+}
+
+// Check if `count` monos of the kind can be allocated, before a batch allocation
+// begins, like building a large array literal. So the batch is all-or-nothing,
+// instead of running out of memory in the middle and leaving a half-built structure.
+//
+// Monos fit into holes of freed monos, the latest region, and the unused content
+// blocks. If they don't, Reserve runs automatic GC when it is on (see Heap.SetGCThreshold),
+// then grows the heap by the blocks they need, up to the max regions. If they still
+// don't fit, it fails. No monos are allocated, so the host must not hold monos other
+// than the roots across the call, like across Allocate.
+func (a *Allocator) Reserve(kind byte, count int) error {
+	heap := a.heap
+	size, err := monoSizeFromKind(kind)
+	if err != nil {
+		return err
+	}
+	heap.mu.Lock()
+	collected := []GCStats{}
+	capacity := a.capacityLocked(size)
+	if capacity < count && heap.gcThreshold > 0 && heap.roots != nil {
+		stats, err := heap.autoGCLocked()
+		if err != nil {
+			heap.mu.Unlock()
+			return err
+		}
+		collected = append(collected, stats...)
+		capacity = a.capacityLocked(size)
+	}
+	// A new region has its counter + kind bytes occupied.
+	if perRegion := int((heap.regionSize - 5) / size); capacity < count && perRegion > 0 {
+		extra := (count - capacity + perRegion - 1) / perRegion
+		if heap.growLocked(extra) == nil {
+			capacity += extra * perRegion
+		}
+	}
+	heap.mu.Unlock()
+
+	// Out of the lock, so the callback can allocate.
+	if heap.onGC != nil {
+		for _, stats := range collected {
+			heap.onGC(stats)
+		}
+	}
+	if capacity < count {
+		return errors.New(fmt.Sprintf(ErrorMessageCannotReserve, count, kind, capacity))
+	}
+	return nil
+}
+
+// How many monos of the size can be allocated without growing the heap.
+func (a *Allocator) capacityLocked(size uint32) int {
+	capacity := 0
+	// A larger hole is split for more monos.
+	for _, bySize := range a.holes {
+		for holeSize, holes := range bySize {
+			capacity += len(holes) * int(holeSize/size)
+		}
+	}
+	if latestRegion := a.latestRegion(); latestRegion != nil {
+		capacity += int((latestRegion.size - latestRegion.counter) / size)
+	}
+	// A new region has its counter + kind bytes occupied.
+	unusedRegions := len(a.heap.content) - int(a.heap.contentCounter) + len(a.free)
+	capacity += unusedRegions * int((a.heap.regionSize-5)/size)
+	return capacity
+}
 
-	wrapped := wrappedConstructor(mono)
-	return wrapped, nil
+// The allocator of the heap, for the interpreter to allocate guest values.
+func (heap *Heap) Allocator() *Allocator {
+	return heap.allocator
 }
 
+// A free region if there is one, or a new region from the heap.
+func (a *Allocator) newRegionLocked() (*Region, error) {
+	if len(a.free) == 0 {
+		return a.heap.newRegionLocked()
+	}
+	region := a.free[len(a.free)-1]
+	a.free = a.free[:len(a.free)-1]
+	return region, nil
+}
+
+// Return nil if the allocator hasn't got any region yet.
 func (a *Allocator) latestRegion() *Region {
+	if len(a.regions) == 0 {
+		return nil
+	}
 	return a.regions[len(a.regions)-1]
 }
 
 func (a *Allocator) Array() (*WrappedArray, error) {
-	wrapped, err := a.Allocate(MONO_ARRAY_S8, func(mono *Mono) *interface{} {
-		var wrapped interface{}
-		wrapped = NewWrappedArray(mono)
-		return &wrapped
-	})
+	mono, err := a.allocateMono(MONO_ARRAY_S8)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrappedArray(mono)
+}
+
+// Allocate an array of the elements, like an array literal `[1, 2, 3]`.
+// All chunks are allocated before the elements are written, so the elements
+// are written chunk by chunk, instead of finding the last chunk for each one.
+func (a *Allocator) ArrayFromSlice(elements []*Mono) (*WrappedArray, error) {
+	wa, err := a.Array()
+	if err != nil {
+		return nil, err
+	}
+	chunks := []*WrappedChunk{wa.defaultChunk}
+	for i := MONO_CHUNK_SIZE; i < len(elements); i += MONO_CHUNK_SIZE {
+		chunk, err := a.Chunk()
+		if err != nil {
+			return nil, err
+		}
+		if err := chunks[len(chunks)-1].WriteNext(chunk.mono.beginFrom); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	for i, element := range elements {
+		if err := chunks[i/MONO_CHUNK_SIZE].Append(element); err != nil {
+			return nil, err
+		}
+	}
+	if err := wa.WriteLength(uint32(len(elements))); err != nil {
+		return nil, err
+	}
+	return wa, nil
+}
+
+// Allocate a string and write the bytes of `s` to it.
+func (a *Allocator) String(s string) (*WrappedString, error) {
+	mono, err := a.allocateMono(MONO_STRING_S8)
+	if err != nil {
+		return nil, err
+	}
+	result, err := NewWrappedString(mono)
+	if err != nil {
+		return nil, err
+	}
+	if err = result.WriteBytes([]byte(s)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Allocate a string of the runes, encoded in UTF-8, like from a string of the interpreter.
+// JavaScript strings are in UTF-16, so a rune out of the BMP, like an emoji, may come
+// as a surrogate pair of two runes: the pair is combined into the rune it stands for.
+// A surrogate not in a pair is not a rune, and is written as U+FFFD, like Go strings do.
+func (a *Allocator) StringFromRunes(runes []rune) (*WrappedString, error) {
+	combined := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		if i+1 < len(runes) && utf16.IsSurrogate(runes[i]) {
+			if r := utf16.DecodeRune(runes[i], runes[i+1]); r != '\uFFFD' {
+				combined = append(combined, r)
+				i++
+				continue
+			}
+		}
+		combined = append(combined, runes[i])
+	}
+	return a.String(string(combined))
+}
+
+// Allocate an int32 and write the value to it.
+func (a *Allocator) Int32(i int32) (*WrappedInt32, error) {
+	mono, err := a.allocateMono(MONO_INT32)
+	if err != nil {
+		return nil, err
+	}
+	result := NewWrappedInt32(mono)
+	if err = result.WriteValue(i); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Allocate a float64 and write the value to it.
+// Guest numbers are all float64, like JavaScript's.
+func (a *Allocator) Float64(f float64) (*WrappedFloat64, error) {
+	mono, err := a.allocateMono(MONO_FLOAT64)
+	if err != nil {
+		return nil, err
+	}
+	result := NewWrappedFloat64(mono)
+	if err = result.WriteValue(f); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Allocate a bool and write the value to it.
+func (a *Allocator) Bool(b bool) (*WrappedBool, error) {
+	mono, err := a.allocateMono(MONO_BOOL)
+	if err != nil {
+		return nil, err
+	}
+	result := NewWrappedBool(mono)
+	if err = result.WriteValue(b); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Allocate an int64 and write the value to it.
+func (a *Allocator) Int64(i int64) (*WrappedInt64, error) {
+	mono, err := a.allocateMono(MONO_INT64)
 	if err != nil {
 		return nil, err
 	}
-	var result *WrappedArray
-	result = (*wrapped).(*WrappedArray)
+	result := NewWrappedInt64(mono)
+	if err = result.WriteValue(i); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
+// Null has no value but the header, so there is no wrapper for it.
+func (a *Allocator) Null() (*Mono, error) {
+	return a.allocateHeaderOnly(MONO_NULL)
+}
+
+// Undefined has no value but the header, so there is no wrapper for it.
+func (a *Allocator) Undefined() (*Mono, error) {
+	return a.allocateHeaderOnly(MONO_UNDEFINED)
+}
+
+func (a *Allocator) allocateHeaderOnly(kind byte) (*Mono, error) {
+	return a.allocateMono(kind)
+}
+
+func (a *Allocator) Chunk() (*WrappedChunk, error) {
+	mono, err := a.allocateMono(MONO_CHUNK_S8)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrappedChunk(mono)
+}
+
+// Allocate an object with its first named-property dictionary linked,
+// like an object literal `{}`, so setting a property doesn't allocate.
+// The object and the dictionary are in the same region, which the selector
+// chooses for both of them, so they are not put into holes left by Heap.Free.
+func (a *Allocator) Object() (*WrappedObject, error) {
+	a.heap.mu.Lock()
+	defer a.heap.mu.Unlock()
+	objectSize, _ := monoSizeFromKind(MONO_OBJECT_S8)
+	dictSize, _ := monoSizeFromKind(MONO_NAMED_PROPERTY_S8)
+	region, err := a.selectRegionLocked(objectSize + dictSize)
+	if err != nil {
+		return nil, err
+	}
+
+	mono, err := region.CreateMono(MONO_OBJECT_S8)
+	if err != nil {
+		return nil, err
+	}
+	wo, err := NewWrappedObject(mono)
+	if err != nil {
+		return nil, err
+	}
+	dict, err := region.CreateMono(MONO_NAMED_PROPERTY_S8)
+	if err != nil {
+		return nil, err
+	}
+	if err := mono.region.writeAddressBarrierLocked(wo.atToDict, dict.beginFrom); err != nil {
+		return nil, err
+	}
+	return wo, nil
+}
+
+// Allocate a named-property dictionary, like one linked after the full last dictionary of an object.
+func (a *Allocator) NamedProperty() (*WrappedNamedProperty, error) {
+	mono, err := a.allocateMono(MONO_NAMED_PROPERTY_S8)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrappedNamedProperty(mono)
+}
+
 // Chunk for array. Since array can contain as many as chunks until
 // out of memory, 1 array is a linked list of chunks.
 //
@@ -739,7 +1678,12 @@ type WrappedChunk struct {
 	atToNext       offset
 }
 
-func NewWrappedChunk(mono *Mono) *WrappedChunk {
+// Error if the mono is not a whole chunk inside its region,
+// so the offsets computed from its end cannot wrap around.
+func NewWrappedChunk(mono *Mono) (*WrappedChunk, error) {
+	if err := mono.validateInRegion(); err != nil {
+		return nil, err
+	}
 	return &WrappedChunk{
 		mono: mono,
 
@@ -748,26 +1692,27 @@ func NewWrappedChunk(mono *Mono) *WrappedChunk {
 		atFirstElement: mono.valueFromOffset + 1,
 
 		// [ #0 ] is the 1 byte chunk length uint8
-		atLength: mono.valueFromOffset + 4,
+		atLength: mono.valueFromOffset,
 
-		// [#-3 - #-0] is the address (pointer) to next chunk
-		atToNext: mono.endOffset - 3,
-	}
+		// [#-4 - #-1] is the address (pointer) to next chunk.
+		// Mono.endOffset is the first byte after the mono, so it is not included.
+		atToNext: mono.endOffset - 4,
+	}, nil
 }
 
 // From the chunk index to region offset.
 //
-// Region: [ ..., #11, #12, #13, #14, ... ]
-// Chunk:       [  #0,  #1,  #2,  #3]
+// Region: [ ..., #11, #12 - #15, #16 - #19, ... ]
+// Chunk:       [  #0,  #1,        #2,      ...]
 //
 // Chunk #0 = 1 byte chunk length
-// Chunk #1 = Chunk.atFirstElement
+// Chunk #1 = Chunk.atFirstElement, the 4 bytes pointer of the element index 0
 //
-// -> OffsetFromIndex(1) == 13
-// -> since Chunk.atFirstElement (12) + 1 = 12
+// -> OffsetFromIndex(1) == 16
+// -> since Chunk.atFirstElement (12) + 1 * 4 = 16
 //
 func (w *WrappedChunk) OffsetFromIndex(index uint8) offset {
-	return w.atFirstElement + uint32(index)
+	return w.atFirstElement + uint32(index)*4
 }
 
 func (w *WrappedChunk) ReadLength() (uint8, error) {
@@ -786,6 +1731,11 @@ func (w *WrappedChunk) WriteLength(length uint8) error {
 //                 11 + 1 * 4  - [ 32bits pointer ]
 //
 func (w *WrappedChunk) Append(element *Mono) error {
+	return w.appendAddress(element.beginFrom)
+}
+
+// Like Append, but the element is an address, which may be a tagged int.
+func (w *WrappedChunk) appendAddress(pointer address) error {
 
 	// The latest unoccupied slot in this chunk is its length.
 	// [#0, #1, #2, (empty),..] --> length: 3, so [#3] is the empty slot.
@@ -798,70 +1748,188 @@ func (w *WrappedChunk) Append(element *Mono) error {
 		return errors.New(ErrorMessageChunkFull)
 	}
 	atWriteTo := w.OffsetFromIndex(currentLength)
-	w.mono.region.WriteAddress(atWriteTo, element.beginFrom)
+	w.mono.region.WriteAddressBarrier(atWriteTo, pointer)
 	w.WriteLength(currentLength + 1)
 	return nil
 }
 
-func IsChunkFull(currentLength uint8) bool {
-	if currentLength+1 > MONO_CHUNK_SIZE {
-		return true
+// Move the first element of the next chunk to the end of this chunk,
+// which must have a slot left. Return the next chunk,
+// or nil if there is no next chunk or it is empty.
+func (w *WrappedChunk) pullFromNext() (*WrappedChunk, error) {
+	next, err := w.FetchNext()
+	if err != nil || next == nil {
+		return nil, err
 	}
-	return false
-}
-
-// Index a mono in the chunk.
-// The caller need to dispatch the mono to a wrapped thing
-// via `mono.kind` before using it.
-//
-func (w *WrappedChunk) Index(idx uint8) (*Mono, error) {
-	atTargetPointer := w.OffsetFromIndex(idx)
-	pointerToTarget, err := w.mono.region.ReadAddress(atTargetPointer)
+	nextLength, err := next.ReadLength()
 	if err != nil {
-		return nil, errors.New(
-			fmt.Sprintf(ErrorMessageCannotReadRegionOffset, atTargetPointer),
-		)
+		return nil, errors.New(ErrorMessageCannotReadChunkLength)
 	}
-	fetched, err := w.mono.region.heap.FetchMono(pointerToTarget)
+	if nextLength == 0 {
+		return nil, nil
+	}
+	first, err := next.mono.region.ReadAddress(next.OffsetFromIndex(0))
 	if err != nil {
 		return nil, err
 	}
-	return fetched, nil
+	length, err := w.ReadLength()
+	if err != nil {
+		return nil, errors.New(ErrorMessageCannotReadChunkLength)
+	}
+	if IsChunkFull(length) {
+		return nil, errors.New(ErrorMessageChunkFull)
+	}
+	if err := w.mono.region.WriteAddressBarrier(w.OffsetFromIndex(length), first); err != nil {
+		return nil, err
+	}
+	if err := w.WriteLength(length + 1); err != nil {
+		return nil, err
+	}
+	return next, next.Remove(0)
 }
 
-// For debugging: loop over the chunk and handle it with the index
-func (w *WrappedChunk) TraverseAddresses(icb func(uint8, address) error) error {
+// Insert the pointer at the index, and move elements from the index up by one.
+// If the chunk is full, its last element is pushed out, and returned
+// for the caller to insert into the next chunk. Otherwise return 0.
+func (w *WrappedChunk) insertAddress(idx uint8, pointer address) (address, error) {
 	length, err := w.ReadLength()
 	if err != nil {
-		return err
+		return 0, errors.New(ErrorMessageCannotReadChunkLength)
 	}
-
-	for i := uint8(0); i < length; i++ {
-		address, err := w.mono.region.ReadAddress(w.OffsetFromIndex(i))
+	if idx > length {
+		return 0, fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length)
+	}
+	overflow := address(0)
+	if IsChunkFull(length) {
+		if overflow, err = w.mono.region.ReadAddress(w.OffsetFromIndex(length - 1)); err != nil {
+			return 0, err
+		}
+		length--
+	}
+	for i := length; i > idx; i-- {
+		moved, err := w.mono.region.ReadAddress(w.OffsetFromIndex(i - 1))
 		if err != nil {
-			return err
+			return 0, err
+		}
+		if err := w.mono.region.WriteAddressBarrier(w.OffsetFromIndex(i), moved); err != nil {
+			return 0, err
 		}
-		icb(i, address)
 	}
-
-	return nil
-}
-
-func (w *WrappedChunk) WriteNext(pointerToNext address) error {
-	return w.mono.region.WriteAddress(w.atToNext, pointerToNext)
+	if err := w.mono.region.WriteAddressBarrier(w.OffsetFromIndex(idx), pointer); err != nil {
+		return 0, err
+	}
+	return overflow, w.WriteLength(length + 1)
 }
 
-func (w *WrappedChunk) FetchNext() (*WrappedChunk, error) {
-	// from latest [-3, -2, -1, -0] is the address of the next chunk
-	pointerNext, err := w.mono.region.ReadAddress(w.atToNext)
+// Remove the element at the index, and move elements after it down by one.
+// The last slot is zeroed, so GC doesn't follow a pointer no element has.
+func (w *WrappedChunk) Remove(idx uint8) error {
+	length, err := w.ReadLength()
 	if err != nil {
-		return nil, err
+		return errors.New(ErrorMessageCannotReadChunkLength)
 	}
-	monoNext, err := w.mono.region.heap.FetchMono(pointerNext)
-	if err != nil {
-		return nil, err
+	if idx >= length {
+		return fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length)
 	}
-	return NewWrappedChunk(monoNext), nil
+	for i := idx; i+1 < length; i++ {
+		pointer, err := w.mono.region.ReadAddress(w.OffsetFromIndex(i + 1))
+		if err != nil {
+			return err
+		}
+		if err := w.mono.region.WriteAddressBarrier(w.OffsetFromIndex(i), pointer); err != nil {
+			return err
+		}
+	}
+	atLast := w.OffsetFromIndex(length - 1)
+	if err := w.mono.region.Zero(atLast, atLast+4); err != nil {
+		return err
+	}
+	return w.WriteLength(length - 1)
+}
+
+func (w *WrappedChunk) IsFull() bool {
+	length, err := w.ReadLength()
+	if err != nil {
+		// Cannot append to a chunk we cannot read.
+		return true
+	}
+	return IsChunkFull(length)
+}
+
+func IsChunkFull(currentLength uint8) bool {
+	if currentLength+1 > MONO_CHUNK_SIZE {
+		return true
+	}
+	return false
+}
+
+// Index a mono in the chunk.
+// The caller need to dispatch the mono to a wrapped thing
+// via `mono.kind` before using it.
+//
+func (w *WrappedChunk) Index(idx uint8) (*Mono, error) {
+	pointerToTarget, err := w.indexAddress(idx)
+	if err != nil {
+		return nil, err
+	}
+	fetched, err := w.mono.region.heap.FetchMono(pointerToTarget)
+	if err != nil {
+		return nil, err
+	}
+	return fetched, nil
+}
+
+// The address in the slot at the index, without fetching the mono,
+// since it may be a tagged int.
+func (w *WrappedChunk) indexAddress(idx uint8) (address, error) {
+	atTargetPointer := w.OffsetFromIndex(idx)
+	pointerToTarget, err := w.mono.region.ReadAddress(atTargetPointer)
+	if err != nil {
+		return 0, errors.New(
+			fmt.Sprintf(ErrorMessageCannotReadRegionOffset, atTargetPointer),
+		)
+	}
+	return pointerToTarget, nil
+}
+
+// For debugging: loop over the chunk and handle it with the index
+func (w *WrappedChunk) TraverseAddresses(icb func(uint8, address) error) error {
+	length, err := w.ReadLength()
+	if err != nil {
+		return err
+	}
+
+	for i := uint8(0); i < length; i++ {
+		address, err := w.mono.region.ReadAddress(w.OffsetFromIndex(i))
+		if err != nil {
+			return err
+		}
+		icb(i, address)
+	}
+
+	return nil
+}
+
+func (w *WrappedChunk) WriteNext(pointerToNext address) error {
+	return w.mono.region.WriteAddressBarrier(w.atToNext, pointerToNext)
+}
+
+// Return nil if there is no next chunk.
+func (w *WrappedChunk) FetchNext() (*WrappedChunk, error) {
+	// from latest [-4, -3, -2, -1] is the address of the next chunk
+	pointerNext, err := w.mono.region.ReadAddress(w.atToNext)
+	if err != nil {
+		return nil, err
+	}
+	// Address 0 is the region header, so no mono can be there.
+	if pointerNext == 0 {
+		return nil, nil
+	}
+	monoNext, err := w.mono.region.heap.FetchMono(pointerNext)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrappedChunk(monoNext)
 }
 
 // Dynamic typed array. Maximum size is uint32.
@@ -873,6 +1941,16 @@ func (w *WrappedChunk) FetchNext() (*WrappedChunk, error) {
 // while other chunks are connected by the pointer at `atToNext`. Read it,
 // to get the pointer of where the next chunk is.
 //
+// The array mono is 45 bytes:
+//
+// [ #0 - #1 ] is this Array mono's header
+// [ #2 - #5 ] is the array length (uint32)
+// [ #6 - #7 ] is the header of the default chunk. It is not written, since the array
+//        is one mono when traversing the region, not an array and a chunk.
+// [ #8 ] is the length of the default chunk (uint8)
+// [ #9 - #40 ] is the 8 slots of the default chunk
+// [ #41 - #44 ] is the address to the next chunk, or 0 if there is none
+//
 type WrappedArray struct {
 	mono           *Mono
 	atDefaultChunk offset
@@ -880,27 +1958,40 @@ type WrappedArray struct {
 	defaultChunk   *WrappedChunk
 }
 
-func NewWrappedArray(mono *Mono) *WrappedArray {
+// Error if the mono is not a whole array mono inside its region,
+// so the default chunk embedded in it is not out of the region either.
+func NewWrappedArray(mono *Mono) (*WrappedArray, error) {
+	if err := mono.validateInRegion(); err != nil {
+		return nil, err
+	}
 	defaultChunkMono, err := mono.region.NewMono(
 		MONO_CHUNK_S8,
 		mono.valueFromOffset+4,
 	)
 	if err != nil {
-		// Should not happen since mono space is allocated.
-		panic(err)
+		return nil, err
+	}
+	defaultChunk, err := NewWrappedChunk(defaultChunkMono)
+	if err != nil {
+		return nil, err
 	}
 	return &WrappedArray{
 		mono: mono,
 
-		// [ #0 ] is this Array mono's kind (at -1 of valueFromOffset)
-		// [ #1 - #4 ] is array length (at +0..3 of valueFromOffset)
-		// [ #5 ] is the beginning of the default Chunk mono (at +4 of valueFromOffset)
+		// [ #0 - #1 ] is this Array mono's header (before valueFromOffset)
+		// [ #2 - #5 ] is array length (at +0..3 of valueFromOffset)
+		// [ #6 ] is the beginning of the default Chunk mono (at +4 of valueFromOffset)
 		atDefaultChunk: mono.valueFromOffset + 4,
 
-		// [ #1 - #4 ] is array length (at +0..3 of valueFromOffset)
+		// [ #2 - #5 ] is array length (at +0..3 of valueFromOffset)
 		atLength:     mono.valueFromOffset,
-		defaultChunk: NewWrappedChunk(defaultChunkMono),
-	}
+		defaultChunk: defaultChunk,
+	}, nil
+}
+
+// The array mono, to put the array into another array or object.
+func (wa *WrappedArray) Mono() *Mono {
+	return wa.mono
 }
 
 // Return array length (how many elements inside)
@@ -919,27 +2010,66 @@ func (wa *WrappedArray) WriteLength(length uint32) error {
 // Return nil if there is no such mono.
 // Error if the index is out of range, or due to other internal errors.
 func (wa *WrappedArray) Index(idx uint32) (*Mono, error) {
-	length, err := wa.ReadLength()
+	pointer, err := wa.IndexAddress(idx)
 	if err != nil {
 		return nil, err
 	}
+	return wa.mono.region.heap.FetchMono(pointer)
+}
+
+// Like Index, but return the address in the slot without fetching the mono,
+// so the element may be a tagged int; see Heap.UntagInt.
+func (wa *WrappedArray) IndexAddress(idx uint32) (address, error) {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return 0, err
+	}
 	if idx >= length {
-		return nil, errors.New(fmt.Sprintf(ErrorMessageIndexOutOfRange, idx, length-1))
+		return 0, fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length-1)
 	}
 	_, chunk, err := wa.findChunk(idx)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	if chunk == nil {
+		return 0, errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
+	}
+
+	// Index inside the chunk.
+	idxChunk := uint8(idx % MONO_CHUNK_SIZE)
+	return chunk.indexAddress(idxChunk)
+}
+
+// Overwrite the element at the index with a pointer to the new element.
+// The array length won't change.
+// Error if the index is out of range, or due to other internal errors.
+func (wa *WrappedArray) Set(idx uint32, element *Mono) error {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return err
+	}
+	if idx >= length {
+		return fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length-1)
+	}
+	_, chunk, err := wa.findChunk(idx)
+	if err != nil {
+		return err
 	}
 	if chunk == nil {
-		return nil, errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
+		return errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
 	}
 
 	// Index inside the chunk.
 	idxChunk := uint8(idx % MONO_CHUNK_SIZE)
-	return chunk.Index(idxChunk)
+	return chunk.mono.region.WriteAddressBarrier(chunk.OffsetFromIndex(idxChunk), element.beginFrom)
 }
 
 func (wa *WrappedArray) Append(element *Mono) error {
+	return wa.AppendAddress(element.beginFrom)
+}
+
+// Like Append, but the element is an address, like a tagged int by Heap.TagInt.
+func (wa *WrappedArray) AppendAddress(element address) error {
 	length, err := wa.ReadLength()
 	if err != nil {
 		return err
@@ -957,11 +2087,367 @@ func (wa *WrappedArray) Append(element *Mono) error {
 		if err != nil {
 			return err
 		}
-		valid.setNext(newChunk.mono.beginFrom)
+		if err := valid.WriteNext(newChunk.mono.beginFrom); err != nil {
+			return err
+		}
 		last = newChunk
 	}
-	last.Append(element)
-	wa.WriteLength(length + 1)
+	if err = last.appendAddress(element); err != nil {
+		return err
+	}
+	return wa.WriteLength(length + 1)
+}
+
+// Remove the last element and return it. Return (nil, nil) if the array is empty.
+//
+// The vacated slot is zeroed so the GC does not treat the popped element as live.
+// If the last element was the only one in its chunk, the chunk is still linked
+// to the array, but empty, so the next Append re-uses it.
+//
+// A tagged int has no mono, so Pop fails with ErrTaggedInt on it, and the element
+// is not removed. Use PopAddress for arrays which may have tagged ints.
+func (wa *WrappedArray) Pop() (*Mono, error) {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	element, err := wa.IndexAddress(length - 1)
+	if err != nil {
+		return nil, err
+	}
+	last, err := wa.mono.region.heap.FetchMono(element)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := wa.PopAddress(); err != nil {
+		return nil, err
+	}
+	return last, nil
+}
+
+// Like Pop, but return the address in the last slot without fetching the mono,
+// since it may be a tagged int. Return 0 if the array is empty.
+func (wa *WrappedArray) PopAddress() (address, error) {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return 0, err
+	}
+	if length == 0 {
+		return 0, nil
+	}
+
+	idx := length - 1
+	_, chunk, err := wa.findChunk(idx)
+	if err != nil {
+		return 0, err
+	}
+	if chunk == nil {
+		return 0, errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
+	}
+
+	idxChunk := uint8(idx % MONO_CHUNK_SIZE)
+	last, err := chunk.indexAddress(idxChunk)
+	if err != nil {
+		return 0, err
+	}
+	atLast := chunk.OffsetFromIndex(idxChunk)
+	if err = chunk.mono.region.Zero(atLast, atLast+4); err != nil {
+		return 0, err
+	}
+	if err = chunk.WriteLength(idxChunk); err != nil {
+		return 0, err
+	}
+	if err = wa.WriteLength(idx); err != nil {
+		return 0, err
+	}
+	return last, nil
+}
+
+// Insert the element at the index, and move all elements from the index up by one,
+// across chunks, like `a.splice(idx, 0, element)` in JavaScript.
+// Inserting at the length is the same as Append.
+func (wa *WrappedArray) Insert(idx uint32, element *Mono) error {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return err
+	}
+	if idx > length {
+		return fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length)
+	}
+	if idx == length {
+		return wa.Append(element)
+	}
+	_, chunk, err := wa.findChunk(idx)
+	if err != nil {
+		return err
+	}
+	if chunk == nil {
+		return errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
+	}
+
+	// Each full chunk pushes its last element to the front of the next one,
+	// until a chunk has a slot left, or a new chunk is appended for it.
+	pointer := element.beginFrom
+	at := uint8(idx % MONO_CHUNK_SIZE)
+	for {
+		overflow, err := chunk.insertAddress(at, pointer)
+		if err != nil {
+			return err
+		}
+		if overflow == 0 {
+			break
+		}
+		next, err := chunk.FetchNext()
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			if next, err = wa.mono.region.heap.allocator.Chunk(); err != nil {
+				return err
+			}
+			if err = chunk.WriteNext(next.mono.beginFrom); err != nil {
+				return err
+			}
+		}
+		chunk, pointer, at = next, overflow, 0
+	}
+	return wa.WriteLength(length + 1)
+}
+
+// Remove the element at the index, and move all elements after it down by one,
+// across chunks, like `a.splice(idx, 1)` in JavaScript.
+//
+// Unlike Pop, if a trailing chunk becomes empty, it is unlinked from the array,
+// and left to the GC.
+func (wa *WrappedArray) Delete(idx uint32) error {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return err
+	}
+	if idx >= length {
+		return fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length)
+	}
+	_, chunk, err := wa.findChunk(idx)
+	if err != nil {
+		return err
+	}
+	if chunk == nil {
+		return errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
+	}
+	if err = chunk.Remove(uint8(idx % MONO_CHUNK_SIZE)); err != nil {
+		return err
+	}
+
+	// Each chunk gives its first element to the one before it.
+	for {
+		next, err := chunk.pullFromNext()
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			break
+		}
+		nextLength, err := next.ReadLength()
+		if err != nil {
+			return errors.New(ErrorMessageCannotReadChunkLength)
+		}
+		if nextLength == 0 {
+			if err = chunk.mono.region.Zero(chunk.atToNext, chunk.atToNext+4); err != nil {
+				return err
+			}
+			break
+		}
+		chunk = next
+	}
+	return wa.WriteLength(length - 1)
+}
+
+// Allocate a new array with elements in [start, end) of this array.
+// Elements are not copied, the new array points to the same monos.
+//
+// Like JavaScript's `Array.prototype.slice`, bounds are clamped instead of erroring:
+// `end` over the length means until the last element, and `start` at or after `end`
+// means an empty array.
+func (wa *WrappedArray) Slice(start, end uint32) (*WrappedArray, error) {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	if end > length {
+		end = length
+	}
+
+	sliced, err := wa.mono.region.heap.allocator.Array()
+	if err != nil {
+		return nil, err
+	}
+	for idx := start; idx < end; idx++ {
+		element, err := wa.Index(idx)
+		if err != nil {
+			return nil, err
+		}
+		if err = sliced.Append(element); err != nil {
+			return nil, err
+		}
+	}
+	return sliced, nil
+}
+
+// Allocate a new array with elements of this array, followed by elements of the other.
+// Both arrays are unchanged, and the new array points to the same monos.
+func (wa *WrappedArray) Concat(other *WrappedArray) (*WrappedArray, error) {
+	joined, err := wa.mono.region.heap.allocator.Array()
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range []*WrappedArray{wa, other} {
+		length, err := source.ReadLength()
+		if err != nil {
+			return nil, err
+		}
+		for idx := uint32(0); idx < length; idx++ {
+			element, err := source.Index(idx)
+			if err != nil {
+				return nil, err
+			}
+			if err = joined.Append(element); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return joined, nil
+}
+
+// Allocate a new array of what the function returns for each element, in order,
+// like `Array.prototype.map` in JavaScript. This array is unchanged.
+// The new array is allocated after all elements are mapped, so it is built at once
+// by Allocator.ArrayFromSlice. Stop at the first error the function returns.
+func (wa *WrappedArray) Map(fn func(*Mono) (*Mono, error)) (*WrappedArray, error) {
+	mapped := []*Mono{}
+	err := wa.ForEach(func(idx uint32, element *Mono) error {
+		result, err := fn(element)
+		if err != nil {
+			return err
+		}
+		mapped = append(mapped, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wa.mono.region.heap.allocator.ArrayFromSlice(mapped)
+}
+
+// Allocate a new array of the elements the predicate returns true for, in order,
+// like `Array.prototype.filter` in JavaScript. This array is unchanged, and the new
+// array points to the same monos. Stop at the first error the predicate returns.
+func (wa *WrappedArray) Filter(pred func(*Mono) (bool, error)) (*WrappedArray, error) {
+	matched := []*Mono{}
+	err := wa.ForEach(func(idx uint32, element *Mono) error {
+		ok, err := pred(element)
+		if err != nil {
+			return err
+		}
+		if ok {
+			matched = append(matched, element)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wa.mono.region.heap.allocator.ArrayFromSlice(matched)
+}
+
+// Return all elements in order, for the host to inspect the array without
+// calling Index in a loop. It walks the chunks only once.
+func (wa *WrappedArray) ToSlice() ([]*Mono, error) {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Mono, 0, length)
+	err = wa.ForEach(func(idx uint32, element *Mono) error {
+		result = append(result, element)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Call the callback with each element in order, and its index, like `forEach` in JavaScript.
+// It walks the chunks only once, like ToSlice, without collecting the elements.
+// Stop at the first error the callback returns, and return it.
+func (wa *WrappedArray) ForEach(cb func(idx uint32, element *Mono) error) error {
+	return wa.forEachAddress(func(idx uint32, pointer address) error {
+		element, err := wa.mono.region.heap.FetchMono(pointer)
+		if err != nil {
+			return err
+		}
+		return cb(idx, element)
+	})
+}
+
+// Like ForEach, but with the addresses in the slots, which may be tagged ints.
+func (wa *WrappedArray) forEachAddress(cb func(idx uint32, element address) error) error {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return err
+	}
+	idx := uint32(0)
+	return wa.traverseChunks(func(chunk *WrappedChunk) error {
+		chunkLength, err := chunk.ReadLength()
+		if err != nil {
+			return err
+		}
+		for i := uint8(0); i < chunkLength && idx < length; i++ {
+			element, err := chunk.indexAddress(i)
+			if err != nil {
+				return err
+			}
+			if err := cb(idx, element); err != nil {
+				return err
+			}
+			idx++
+		}
+		return nil
+	})
+}
+
+// Return the first index of the element which is the target mono, or -1 if absent.
+// Elements are compared by reference (address), not by value,
+// and the element monos are not fetched.
+func (wa *WrappedArray) IndexOf(target *Mono) (int64, error) {
+	length, err := wa.ReadLength()
+	if err != nil {
+		return -1, err
+	}
+	chunk := wa.defaultChunk
+	for idx := uint32(0); idx < length; idx++ {
+		idxChunk := uint8(idx % MONO_CHUNK_SIZE)
+		if idx > 0 && idxChunk == 0 {
+			chunk, err = chunk.FetchNext()
+			if err != nil {
+				return -1, err
+			}
+			if chunk == nil {
+				return -1, errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
+			}
+		}
+		pointer, err := chunk.mono.region.ReadAddress(chunk.OffsetFromIndex(idxChunk))
+		if err != nil {
+			return -1, err
+		}
+		if pointer == target.beginFrom {
+			return int64(idx), nil
+		}
+	}
+	return -1, nil
 }
 
 // Find a chunk the index should be in.
@@ -980,9 +2466,9 @@ func (wa *WrappedArray) findChunk(idx uint32) (*WrappedChunk, *WrappedChunk, err
 	// At which chunk
 	atChunk := (idx / MONO_CHUNK_SIZE >> 0)
 
-	// If at the Array default chunk (#0 chunk)
+	// If at the Array default chunk (#0 chunk), which is always there.
 	if atChunk == 0 {
-		return wa.defaultChunk, nil, nil
+		return wa.defaultChunk, wa.defaultChunk, nil
 	} else {
 		validChunk = wa.defaultChunk
 		fetchedChunk = wa.defaultChunk
@@ -1007,44 +2493,59 @@ func (wa *WrappedArray) findChunk(idx uint32) (*WrappedChunk, *WrappedChunk, err
 	return targetChunk, targetChunk, nil
 }
 
+// Loop over chunks which contain elements, from the default chunk.
+// An empty array has no such chunk, so the callback is never called.
 func (wa *WrappedArray) traverseChunks(cb func(*WrappedChunk) error) error {
 	length, err := wa.ReadLength()
 	if err != nil {
 		return err
 	}
+	if length == 0 {
+		return nil
+	}
 
 	// Ex: We have in total 10 elements and each chunk size is 8,
-	// so 10 - 1 / 8 >> 0 = #2 chunk is where the #9 (10th) element is.
-	lastChunkId := length - 1/MONO_CHUNK_SIZE>>0
+	// so (10 - 1) / 8 = #1 chunk is where the #9 (10th) element is.
+	lastChunkId := (length - 1) / MONO_CHUNK_SIZE
 
-	if lastChunkId == 0 { // default chunk only.
-		cb(wa.defaultChunk)
-	} else {
-		validChunk := wa.defaultChunk
-		fetchedChunk := wa.defaultChunk
-		for chunkId := uint32(0); chunkId < lastChunkId; chunkId++ {
-			fetchedChunk, err = validChunk.FetchNext()
-			if err != nil {
-				return err
-			}
-			if err = cb(fetchedChunk); err != nil {
-				return err
-			}
-			// Set +1 chunk as where to find in the next iteration.
-			validChunk = fetchedChunk
+	if err = cb(wa.defaultChunk); err != nil {
+		return err
+	}
+	validChunk := wa.defaultChunk
+	for chunkId := uint32(0); chunkId < lastChunkId; chunkId++ {
+		fetchedChunk, err := validChunk.FetchNext()
+		if err != nil {
+			return err
+		}
+		if fetchedChunk == nil {
+			return errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, length-1))
+		}
+		if err = cb(fetchedChunk); err != nil {
+			return err
 		}
+		// Set +1 chunk as where to find in the next iteration.
+		validChunk = fetchedChunk
 	}
 	return nil
 }
 
+// The chunk where the last element is.
+// For an empty array, it is the default chunk, where the first element will be.
 func (wa *WrappedArray) lastChunk() (*WrappedChunk, error) {
-	_, last, err := wa.findChunk(wa.ReadLength() - 1)
+	length, err := wa.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return wa.defaultChunk, nil
+	}
+	_, last, err := wa.findChunk(length - 1)
 	if err != nil {
 		return nil, err
 	}
 
 	if last == nil {
-		return nil, errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, idx))
+		return nil, errors.New(fmt.Sprintf(ErrorMessageIndexedChunkOutOfRange, length-1))
 	}
 
 	return last, nil
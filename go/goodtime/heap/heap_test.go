@@ -0,0 +1,1071 @@
+package heap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHeapGrow(t *testing.T) {
+	heap := NewHeap()
+
+	// Exhaust all pre-allocated regions.
+	for i := 0; i < NUMBER_REGIONS; i++ {
+		if _, err := heap.NewRegion(); err != nil {
+			t.Fatalf("Cannot create region #%d: %v", i, err)
+		}
+	}
+	if _, err := heap.NewRegion(); err == nil {
+		t.Fatalf("Expect heap full after %d regions", NUMBER_REGIONS)
+	}
+
+	if err := heap.Grow(16); err != nil {
+		t.Fatal(err)
+	}
+	region, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region.beginFrom != NUMBER_REGIONS*REGION_SIZE {
+		t.Errorf("Grown region begins from %d, expect %d", region.beginFrom, NUMBER_REGIONS*REGION_SIZE)
+	}
+
+	mono, err := region.CreateMono(MONO_INT32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, err := heap.FetchMono(mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.kind != MONO_INT32 {
+		t.Errorf("Fetched mono kind %d, expect %d", fetched.kind, MONO_INT32)
+	}
+}
+
+func TestHeapGrowOverMax(t *testing.T) {
+	heap := NewHeap()
+	if err := heap.Grow(MAX_NUMBER_REGIONS); err == nil {
+		t.Errorf("Expect error when growing over %d regions", MAX_NUMBER_REGIONS)
+	}
+
+	heap = NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 2, MaxRegions: 4})
+	if err := heap.Grow(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := heap.Grow(1); err == nil {
+		t.Errorf("Expect error when growing over %d regions", 4)
+	}
+}
+
+func TestHeapOverAddressable(t *testing.T) {
+	// 4GB is the most pointers can address.
+	if err := (HeapConfig{RegionSize: 1 << 20, NumberRegions: 1 << 12}).Check(); err != nil {
+		t.Errorf("Expect a heap of 4GB: %v", err)
+	}
+	if err := (HeapConfig{RegionSize: 1 << 20, NumberRegions: 1<<12 + 1}).Check(); err == nil {
+		t.Errorf("Expect error for a heap over 4GB")
+	}
+	if err := (HeapConfig{RegionSize: 1 << 31, NumberRegions: 3}).Check(); err == nil {
+		t.Errorf("Expect error for a heap over 4GB")
+	}
+
+	// Blocks of the heap are only counted, so they are left nil instead of taking 4GB.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 1 << 20, NumberRegions: 1, MaxRegions: 1 << 13})
+	heap.content = make([][]byte, 1<<12-1)
+	if err := heap.Grow(1); err != nil {
+		t.Fatalf("Cannot grow a heap to 4GB: %v", err)
+	}
+	if err := heap.Grow(1); err == nil {
+		t.Errorf("Expect error when growing a heap over 4GB")
+	}
+}
+
+func TestNewHeapWithConfig(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	if len(heap.content) != 4 {
+		t.Fatalf("Heap has %d regions, expect %d", len(heap.content), 4)
+	}
+
+	// 4096 bytes can contain (4096 - 5) / 5 MONO_INT32 only,
+	// so the allocator must cross to the next regions.
+	addresses := []address{}
+	for i := 0; i < 1000; i++ {
+		mono := allocateMono(t, heap, MONO_INT32)
+		addresses = append(addresses, mono.beginFrom)
+	}
+	if len(heap.allocator.regions) != 2 {
+		t.Errorf("Allocator has %d regions, expect %d", len(heap.allocator.regions), 2)
+	}
+	for i, region := range heap.allocator.regions {
+		if region.beginFrom != uint64(i)*4096 || region.endAt != uint64(i+1)*4096-1 {
+			t.Errorf("Region #%d is [%d, %d]", i, region.beginFrom, region.endAt)
+		}
+	}
+	for _, address := range addresses {
+		mono, err := heap.FetchMono(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.kind != MONO_INT32 {
+			t.Errorf("Mono at %d has kind %d, expect %d", address, mono.kind, MONO_INT32)
+		}
+	}
+
+	// Out of the 4 regions.
+	if _, err := heap.FetchMono(4 * 4096); err == nil {
+		t.Errorf("Expect error when fetching out of the heap")
+	}
+}
+
+func TestRegionTraverseFrom(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	monos := []*Mono{
+		allocateMono(t, heap, MONO_INT32),
+		allocateMono(t, heap, MONO_FLOAT64),
+		allocateMono(t, heap, MONO_ARRAY_S8),
+		allocateMono(t, heap, MONO_INT32),
+	}
+	region := monos[0].region
+
+	visited := []*Mono{}
+	err := region.traverseFrom(monos[1].beginOffset, func(mono *Mono) error {
+		visited = append(visited, mono)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != 3 {
+		t.Fatalf("Visited %d monos, expect %d", len(visited), 3)
+	}
+	for i, mono := range visited {
+		expected := monos[i+1]
+		if mono.beginOffset != expected.beginOffset || mono.kind != expected.kind {
+			t.Errorf("Visited #%d at %d (kind %d), expect at %d (kind %d)",
+				i, mono.beginOffset, mono.kind, expected.beginOffset, expected.kind)
+		}
+	}
+
+	// Inside the float64 mono, not its header.
+	err = region.traverseFrom(monos[1].beginOffset+1, func(mono *Mono) error {
+		t.Errorf("Visited mono at %d from a wrong start", mono.beginOffset)
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Expect error when traversing from a non-header offset")
+	}
+}
+
+func TestRegionTraverseUntil(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	monos := []*Mono{
+		allocateMono(t, heap, MONO_INT32),
+		allocateMono(t, heap, MONO_FLOAT64),
+		allocateMono(t, heap, MONO_INT32),
+	}
+
+	called := 0
+	err := monos[0].region.TraverseUntil(func(mono *Mono) (bool, error) {
+		called++
+		return mono.beginOffset == monos[1].beginOffset, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called != 2 {
+		t.Errorf("Callback is called %d times, expect %d", called, 2)
+	}
+}
+
+func TestRegionMonos(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocated := []*Mono{
+		allocateMono(t, heap, MONO_INT32),
+		allocateMono(t, heap, MONO_STRING_S8),
+		allocateMono(t, heap, MONO_BOOL),
+		allocateMono(t, heap, MONO_ARRAY_S8),
+		allocateMono(t, heap, MONO_NULL),
+		allocateMono(t, heap, MONO_FLOAT64),
+	}
+
+	monos, err := allocated[0].region.Monos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(monos) != len(allocated) {
+		t.Fatalf("Monos reads %d monos, expect %d", len(monos), len(allocated))
+	}
+	for i, mono := range monos {
+		expected := allocated[i]
+		if mono.beginOffset != expected.beginOffset || mono.kind != expected.kind {
+			t.Errorf("Mono #%d is at %d (kind %d), expect at %d (kind %d)",
+				i, mono.beginOffset, mono.kind, expected.beginOffset, expected.kind)
+		}
+	}
+}
+
+func TestMonoAccessors(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, kind := range []byte{MONO_INT32, MONO_FLOAT64, MONO_ARRAY_S8, MONO_STRING_S8, MONO_BOOL, MONO_NULL} {
+		allocated := allocateMono(t, heap, kind)
+		mono, err := heap.FetchMono(allocated.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, err := monoSizeFromKind(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mono.Kind() != kind || mono.Size() != size {
+			t.Errorf("Mono is of kind %d and %d bytes, expect %d and %d", mono.Kind(), mono.Size(), kind, size)
+		}
+		if mono.BeginFrom() != allocated.beginFrom || mono.EndAt() != allocated.beginFrom+uint64(size) {
+			t.Errorf("Mono is at [%d, %d), expect [%d, %d)",
+				mono.BeginFrom(), mono.EndAt(), allocated.beginFrom, allocated.beginFrom+uint64(size))
+		}
+	}
+}
+
+func TestMonoEquals(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocated := allocateTestInt32(t, heap, 1)
+	foo, err := heap.FetchMono(allocated.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := heap.FetchMono(allocated.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foo == bar || !foo.Equals(bar) {
+		t.Errorf("Monos fetched twice from %d are not equal", allocated.beginFrom)
+	}
+
+	// Equal value, but another mono.
+	if foo.Equals(allocateTestInt32(t, heap, 1)) {
+		t.Errorf("Different monos are equal")
+	}
+	// The same address on another heap.
+	other := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	if foo.Equals(allocateTestInt32(t, other, 1)) {
+		t.Errorf("Monos on different heaps are equal")
+	}
+	if foo.Equals(nil) {
+		t.Errorf("Mono equals nil")
+	}
+}
+
+func TestKindName(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	for kind, expected := range map[byte]string{
+		REGION_EDEN:     "Eden",
+		REGION_SURVIVOR: "Survivor",
+		REGION_TENURED:  "Tenured",
+		REGION_HUMOGOUS: "Humongous",
+	} {
+		region, err := heap.NewRegion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := region.WriteKind(kind); err != nil {
+			t.Fatal(err)
+		}
+		if region.Kind() != kind || region.KindName() != expected {
+			t.Errorf("Region is of kind %d (%s), expect %d (%s)", region.Kind(), region.KindName(), kind, expected)
+		}
+	}
+	if name := RegionKindName(99); name != "Unknown(99)" {
+		t.Errorf("Region kind 99 is named %q, expect %q", name, "Unknown(99)")
+	}
+
+	mono := allocateMono(t, heap, MONO_ARRAY_S8)
+	if mono.KindName() != "Array" {
+		t.Errorf("Mono of kind %d is named %q, expect %q", mono.kind, mono.KindName(), "Array")
+	}
+	if name := MonoKindName(99); name != "Unknown(99)" {
+		t.Errorf("Mono kind 99 is named %q, expect %q", name, "Unknown(99)")
+	}
+}
+
+func TestRegionUsedAndFreeBytes(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocateTestInt32(t, heap, 1)
+	region := allocateTestInt32(t, heap, 2).region
+	if region.UsedBytes() != 12 {
+		t.Errorf("UsedBytes reads %d, expect %d", region.UsedBytes(), 12)
+	}
+	if region.FreeBytes() != 4096-5-12 {
+		t.Errorf("FreeBytes reads %d, expect %d", region.FreeBytes(), 4096-5-12)
+	}
+}
+
+func TestRegionClone(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	mono := allocateTestInt32(t, heap, 42)
+	region := mono.region
+	counter := region.counter
+	original := append([]byte{}, region.content...)
+
+	clone := region.Clone()
+	if clone.counter != counter || clone.kind != region.kind || clone.beginFrom != region.beginFrom {
+		t.Errorf("Clone has counter %d, kind %d and begins from %d, expect %d, %d and %d",
+			clone.counter, clone.kind, clone.beginFrom, counter, region.kind, region.beginFrom)
+	}
+	if err := clone.WriteInt32(mono.valueFromOffset, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.CreateMono(MONO_FLOAT64); err != nil {
+		t.Fatal(err)
+	}
+
+	if region.counter != counter {
+		t.Errorf("Region counter reads %d after the clone allocates, expect %d", region.counter, counter)
+	}
+	if !bytes.Equal(region.content, original) {
+		t.Errorf("Region content is changed by writing to the clone")
+	}
+	i, err := clone.ReadInt32(mono.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 7 {
+		t.Errorf("Clone reads %d, expect %d", i, 7)
+	}
+}
+
+func TestRegionReset(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocateTestInt32(t, heap, 42)
+	mono := allocateTestFloat64(t, heap, 2.5)
+	region := mono.region
+	if err := region.WriteKind(REGION_SURVIVOR); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := region.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	// Read from the content, not the fields Reset sets.
+	formed := heap.RegionFromContent(region.beginFrom, region.size, region.content)
+	if formed.counter != 5 || formed.kind != REGION_EDEN {
+		t.Errorf("Region after reset has counter %d and kind %d, expect %d and %d",
+			formed.counter, formed.kind, 5, REGION_EDEN)
+	}
+	for i, b := range region.content[5:] {
+		if b != 0 {
+			t.Fatalf("Byte at %d reads %d after reset, expect %d", 5+i, b, 0)
+		}
+	}
+
+	reused := allocateMono(t, heap, MONO_INT32)
+	if reused.beginFrom != region.beginFrom+5 {
+		t.Errorf("Mono allocated after reset is at %d, expect %d", reused.beginFrom, region.beginFrom+5)
+	}
+}
+
+func TestHeapAddresses(t *testing.T) {
+	// Small regions, so monos are in more than one region.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 128, NumberRegions: 16})
+	expected := []address{}
+	for i := 0; i < 10; i++ {
+		expected = append(expected,
+			allocateMono(t, heap, MONO_FLOAT64).beginFrom,
+			allocateMono(t, heap, MONO_ARRAY_S8).beginFrom)
+	}
+
+	addresses, err := heap.Addresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != len(expected) {
+		t.Fatalf("Addresses reads %d monos, expect %d", len(addresses), len(expected))
+	}
+	for i, addr := range addresses {
+		if addr != expected[i] {
+			t.Errorf("Address #%d reads %d, expect %d", i, addr, expected[i])
+		}
+		if addr%uint64(heap.regionSize) < 5 {
+			t.Errorf("Address #%d is %d, in the region header", i, addr)
+		}
+	}
+}
+
+func TestAllocateConcurrently(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 64})
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := heap.allocator.Int64(int64(i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	addresses, err := heap.Addresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 8*100 {
+		t.Errorf("Heap has %d monos, expect %d", len(addresses), 8*100)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after allocating concurrently: %v", err)
+	}
+}
+
+func TestWriteConcurrently(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 64, TenureThreshold: 1})
+	// Tenured arrays, so appending young monos to them remembers their slots.
+	roots := []address{}
+	for g := 0; g < 8; g++ {
+		roots = append(roots, allocateTestArray(t, heap).mono.beginFrom)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := heap.MinorGC(roots); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	interned := make([]address, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			mono, err := heap.FetchMono(roots[g])
+			if err != nil {
+				errs <- err
+				return
+			}
+			wa, err := NewWrappedArray(mono)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < 20; i++ {
+				wi, err := heap.allocator.Int32(int32(i))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := wa.Append(wi.Mono()); err != nil {
+					errs <- err
+					return
+				}
+				if interned[g], err = heap.Intern("goodtime"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for g := 1; g < 8; g++ {
+		if interned[g] != interned[0] {
+			t.Errorf("Interning in goroutine #%d gives %d, expect %d", g, interned[g], interned[0])
+		}
+	}
+	// The 8 slots of each tenured default chunk, and its pointer to the young chunk after it.
+	if len(heap.remembered) != 8*9 {
+		t.Errorf("Remembered set has %d slots, expect %d", len(heap.remembered), 8*9)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after writing concurrently: %v", err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 128, NumberRegions: 2, MaxRegions: 2})
+	var err error
+	for err == nil {
+		_, err = heap.allocator.Array()
+	}
+	if !errors.Is(err, ErrHeapFull) {
+		t.Errorf("Allocating on a full heap returns %v, expect %v", err, ErrHeapFull)
+	}
+
+	region, _, err := heap.RegionOf(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := region.CreateMono(MONO_ARRAY_S8); !errors.Is(err, ErrRegionFull) {
+		t.Errorf("Creating a mono in a full region returns %v, expect %v", err, ErrRegionFull)
+	}
+	if _, err := region.CreateMono(99); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("Creating a mono of kind 99 returns %v, expect %v", err, ErrUnknownKind)
+	}
+	monos, err := region.Monos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa, err := NewWrappedArray(monos[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wa.Index(0); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("Index of an empty array returns %v, expect %v", err, ErrIndexOutOfRange)
+	}
+}
+
+type countingLogger struct {
+	messages int
+}
+
+func (logger *countingLogger) Printf(format string, v ...interface{}) {
+	logger.messages += 1
+}
+
+func TestHeapLogger(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	mono := allocateMono(t, heap, MONO_INT32)
+	allocateMono(t, heap, MONO_FLOAT64)
+	allocateMono(t, heap, MONO_BOOL)
+
+	// Nothing is logged by default.
+	if _, err := mono.region.Monos(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &countingLogger{}
+	heap.SetLogger(logger)
+	if _, err := mono.region.Monos(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.messages != 3 {
+		t.Errorf("Logger gets %d messages, expect %d", logger.messages, 3)
+	}
+
+	heap.SetLogger(nil)
+	if _, err := mono.region.Monos(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.messages != 3 {
+		t.Errorf("Logger gets %d messages after removed, expect %d", logger.messages, 3)
+	}
+}
+
+func TestAllocatorReserve(t *testing.T) {
+	// The heap cannot grow, so only the 2 regions count.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 2, MaxRegions: 2})
+	allocateMono(t, heap, MONO_INT32)
+	region := heap.allocator.latestRegion()
+	counter := region.counter
+
+	// (4096 - 11) / 6 + (4096 - 5) / 6 = 680 + 681
+	if err := heap.allocator.Reserve(MONO_INT32, 1361); err != nil {
+		t.Errorf("Expect 1361 monos can fit: %v", err)
+	}
+	if err := heap.allocator.Reserve(MONO_INT32, 1362); err == nil {
+		t.Errorf("Expect error when 1362 monos cannot fit")
+	}
+	if err := heap.allocator.Reserve(MONO_ARRAY_S8, 1000); err == nil {
+		t.Errorf("Expect error when 1000 arrays cannot fit")
+	}
+
+	// Nothing is allocated.
+	if region.counter != counter || heap.contentCounter != 1 {
+		t.Errorf("Reserve allocates: counter %d vs. %d, %d regions",
+			region.counter, counter, heap.contentCounter)
+	}
+
+	// Holes of freed monos count.
+	mono := allocateMono(t, heap, MONO_ARRAY_S8)
+	for i := 0; i < 1361-2*7; i++ {
+		allocateMono(t, heap, MONO_INT32)
+	}
+	if err := heap.Free(mono.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+	// 45 bytes of the array are a hole for 7 int32 monos.
+	if err := heap.allocator.Reserve(MONO_INT32, 7); err != nil {
+		t.Errorf("Expect 7 monos can fit into the hole: %v", err)
+	}
+}
+
+func TestAllocatorReserveGrowing(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 2, MaxRegions: 4})
+	if err := heap.allocator.Reserve(MONO_INT32, 681*3); err != nil {
+		t.Errorf("Expect the heap to grow for 3 regions of monos: %v", err)
+	}
+	if len(heap.content) != 3 {
+		t.Errorf("Heap has %d content blocks after reserving, expect %d", len(heap.content), 3)
+	}
+	if err := heap.allocator.Reserve(MONO_INT32, 681*4+1); err == nil {
+		t.Errorf("Expect error when monos cannot fit in the max regions")
+	}
+	for i := 0; i < 681*3; i++ {
+		allocateMono(t, heap, MONO_INT32)
+	}
+}
+
+func TestAllocatorReserveCollecting(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 64, NumberRegions: 8, MaxRegions: 8})
+	roots := []address{allocateTestInt32(t, heap, 42).beginFrom}
+	heap.SetRoots(func() []address {
+		return roots
+	})
+	heap.SetGCThreshold(0.5)
+	collected := 0
+	heap.OnGC(func(stats GCStats) {
+		collected++
+	})
+	// Garbage fills the heap, except a region for GC to copy the root to.
+	for heap.RegionCount() < 7 {
+		if _, err := heap.allocator.allocateMono(MONO_INT32); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := heap.allocator.Reserve(MONO_INT32, 20); err != nil {
+		t.Errorf("Expect monos to fit after GC: %v", err)
+	}
+	if collected == 0 {
+		t.Errorf("No GC runs while reserving over the capacity")
+	}
+}
+
+func TestRegionUint16(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	region, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last 2 bytes of the region.
+	at := region.size - 2
+	if err := region.WriteUint16(at, 0xFFFF); err != nil {
+		t.Fatal(err)
+	}
+	i, err := region.ReadUint16(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 0xFFFF {
+		t.Errorf("Uint16 reads %#x, expect %#x", i, 0xFFFF)
+	}
+	if region.content[at] != 0xFF || region.content[at+1] != 0xFF {
+		t.Errorf("Uint16 bytes %#v", region.content[at:])
+	}
+
+	// Only 1 byte left.
+	if err := region.WriteUint16(region.size-1, 1); err == nil {
+		t.Errorf("Expect error when writing over the region end")
+	}
+	if _, err := region.ReadUint16(region.size - 1); err == nil {
+		t.Errorf("Expect error when reading over the region end")
+	}
+
+	counter := region.counter
+	if err := region.NewUint16(region.counter, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if region.counter != counter+2 {
+		t.Errorf("Counter %d, expect %d", region.counter, counter+2)
+	}
+	mono, err := region.CreateMono(MONO_UINT16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mono.endOffset-mono.beginOffset != 4 {
+		t.Errorf("Uint16 mono size %d, expect %d", mono.endOffset-mono.beginOffset, 4)
+	}
+}
+
+func TestRegionByteOrder(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	content := heap.content[0]
+	bigEndian := heap.RegionFromContentWithOrder(0, 4096, content, binary.BigEndian)
+	if err := bigEndian.WriteUint32(8, 0x01020304); err != nil {
+		t.Fatal(err)
+	}
+	if err := bigEndian.WriteUint64(16, 0x0102030405060708); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content[8:12], []byte{1, 2, 3, 4}) {
+		t.Errorf("Big-endian bytes %#v", content[8:12])
+	}
+
+	// The same content read in little-endian.
+	littleEndian := heap.RegionFromContent(0, 4096, content)
+	i32, err := littleEndian.ReadUint32(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i32 != 0x04030201 {
+		t.Errorf("Little-endian reads %#x, expect %#x", i32, 0x04030201)
+	}
+	i64, err := littleEndian.ReadUint64(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i64 != 0x0807060504030201 {
+		t.Errorf("Little-endian reads %#x, expect %#x", i64, uint64(0x0807060504030201))
+	}
+
+	// Read back in the written order.
+	i32, err = bigEndian.ReadUint32(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i32 != 0x01020304 {
+		t.Errorf("Big-endian reads %#x, expect %#x", i32, 0x01020304)
+	}
+}
+
+func TestRegionReadOverEnd(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	region, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only 2 bytes left: must be an error, not a panic from slicing.
+	at := region.size - 2
+	if _, err := region.ReadUint32(at); err == nil {
+		t.Errorf("Expect error when reading uint32 at %d", at)
+	}
+	if _, err := region.ReadInt32(at); err == nil {
+		t.Errorf("Expect error when reading int32 at %d", at)
+	}
+	if _, err := region.ReadAddress(at); err == nil {
+		t.Errorf("Expect error when reading address at %d", at)
+	}
+	if _, err := region.ReadUint64(region.size - 4); err == nil {
+		t.Errorf("Expect error when reading uint64 at %d", region.size-4)
+	}
+	if _, err := region.ReadFloat64(region.size - 4); err == nil {
+		t.Errorf("Expect error when reading float64 at %d", region.size-4)
+	}
+
+	// Exactly the last bytes.
+	if _, err := region.ReadUint32(region.size - 4); err != nil {
+		t.Errorf("Expect reading uint32 at %d: %v", region.size-4, err)
+	}
+	if _, err := region.ReadUint64(region.size - 8); err != nil {
+		t.Errorf("Expect reading uint64 at %d: %v", region.size-8, err)
+	}
+
+	// The last byte is at size - 1, and there is no byte at size.
+	if _, err := region.ReadUint8(region.size - 1); err != nil {
+		t.Errorf("Expect reading uint8 at %d: %v", region.size-1, err)
+	}
+	if _, err := region.ReadUint8(region.size); err == nil {
+		t.Errorf("Expect error when reading uint8 at %d", region.size)
+	}
+	if _, err := region.ReadByte(region.size); err == nil {
+		t.Errorf("Expect error when reading byte at %d", region.size)
+	}
+	if _, err := region.ReadInt8(region.size - 1); err != nil {
+		t.Errorf("Expect reading int8 at %d: %v", region.size-1, err)
+	}
+	if _, err := region.ReadInt8(region.size); err == nil {
+		t.Errorf("Expect error when reading int8 at %d", region.size)
+	}
+}
+
+func TestOffsetWrapAround(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	region, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// `at + 4` wraps around to 2, but it must not pass the check.
+	at := offset(math.MaxUint32 - 1)
+	if _, err := region.ReadUint32(at); err == nil {
+		t.Errorf("Expect error when reading uint32 at %d", at)
+	}
+	if err := region.WriteUint64(at, 1); err == nil {
+		t.Errorf("Expect error when writing uint64 at %d", at)
+	}
+
+	// A chunk near offset 0, which is too short, so `endOffset - 4` would wrap around.
+	mono := &Mono{
+		region:          region,
+		kind:            MONO_CHUNK_S8,
+		beginOffset:     0,
+		endOffset:       2,
+		valueFromOffset: 1,
+	}
+	if _, err := NewWrappedChunk(mono); err == nil {
+		t.Errorf("Expect error when wrapping a chunk at [%d, %d)", mono.beginOffset, mono.endOffset)
+	}
+
+	// Over the region end.
+	mono = &Mono{
+		region:          region,
+		kind:            MONO_CHUNK_S8,
+		beginOffset:     region.size - 10,
+		endOffset:       region.size + 28,
+		valueFromOffset: region.size - 9,
+	}
+	if _, err := NewWrappedChunk(mono); err == nil {
+		t.Errorf("Expect error when wrapping a chunk at [%d, %d)", mono.beginOffset, mono.endOffset)
+	}
+}
+
+func TestMonoHeaderSizes(t *testing.T) {
+	// Sizes with the 1-byte header before flags are added.
+	for kind, before := range map[byte]uint32{
+		MONO_INT32:             5,
+		MONO_ADDRESS:           5,
+		MONO_FLOAT64:           9,
+		MONO_INT64:             9,
+		MONO_UINT16:            3,
+		MONO_CHUNK_S8:          38,
+		MONO_STRING_S8:         73,
+		MONO_OBJECT_S8:         73,
+		MONO_NAMED_PROPERTY_S8: 69,
+		MONO_BOOL:              2,
+		MONO_NULL:              1,
+		MONO_UNDEFINED:         1,
+		MONO_FORWARD:           9,
+		// The default chunk embedded has a header as well.
+		MONO_ARRAY_S8: 43 + 1,
+	} {
+		size, err := monoSizeFromKind(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != before+1 {
+			t.Errorf("%s mono is %d bytes, expect %d", MonoKindName(kind), size, before+1)
+		}
+	}
+}
+
+func TestMonoHeaderRoundTrip(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	i32 := allocateTestInt32(t, heap, -42)
+	f64 := allocateTestFloat64(t, heap, math.Inf(-1))
+	wb, err := heap.allocator.Bool(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := heap.allocator.String("goodtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa := allocateTestArray(t, heap, i32, f64)
+
+	// Flags don't change the kind or the value after the header.
+	const flag = 1 << 3
+	for _, mono := range []*Mono{i32, f64, wb.mono, ws.mono, wa.mono} {
+		if err := mono.SetFlag(flag, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := mono.SetFlag(1, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := mono.SetFlag(1, false); err != nil {
+			t.Fatal(err)
+		}
+		fetched, err := heap.FetchMono(mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fetched.kind != mono.kind {
+			t.Errorf("Mono at %d is of kind %d after setting flags, expect %d", mono.beginFrom, fetched.kind, mono.kind)
+		}
+		for f, expected := range map[byte]bool{flag: true, 1: false} {
+			set, err := fetched.GetFlag(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if set != expected {
+				t.Errorf("Flag %d of the mono at %d is %t, expect %t", f, mono.beginFrom, set, expected)
+			}
+		}
+	}
+
+	if i, err := NewWrappedInt32(i32).ReadValue(); err != nil || i != -42 {
+		t.Errorf("Int32 reads %d (%v), expect %d", i, err, -42)
+	}
+	if f, err := NewWrappedFloat64(f64).ReadValue(); err != nil || !math.IsInf(f, -1) {
+		t.Errorf("Float64 reads %f (%v), expect %f", f, err, math.Inf(-1))
+	}
+	if b, err := wb.ReadValue(); err != nil || !b {
+		t.Errorf("Bool reads %t (%v), expect %t", b, err, true)
+	}
+	if s, err := ws.ReadGoString(); err != nil || s != "goodtime" {
+		t.Errorf("String reads %q (%v), expect %q", s, err, "goodtime")
+	}
+	elements, err := wa.ToSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 || elements[0].beginFrom != i32.beginFrom || elements[1].beginFrom != f64.beginFrom {
+		t.Errorf("Array reads %v, expect the int32 and float64", elements)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after setting flags: %v", err)
+	}
+}
+
+func TestRegionWriteAndReadString(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	region, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := strings.Repeat("goodtime", 12) + "ハロー"
+	if len(s) != 105 {
+		t.Fatalf("String is %d bytes, expect %d", len(s), 105)
+	}
+
+	next, err := region.WriteString(100, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 100+105 {
+		t.Errorf("Next offset is %d, expect %d", next, 100+105)
+	}
+	read, err := region.ReadString(100, 105)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != s {
+		t.Errorf("String reads %q, expect %q", read, s)
+	}
+
+	// Only part of it would fit at the end of the region.
+	if _, err := region.WriteString(4096-10, s); err == nil {
+		t.Errorf("Expect error when writing a string over the region end")
+	}
+	if region.content[4096-10] != 0 {
+		t.Errorf("String is partly written over the region end")
+	}
+	if _, err := region.ReadString(4096-10, 105); err == nil {
+		t.Errorf("Expect error when reading a string over the region end")
+	}
+}
+
+func TestRegionCount(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	if count := heap.RegionCount(); count != 0 {
+		t.Errorf("Empty heap has %d regions, expect %d", count, 0)
+	}
+	for expected := 1; expected <= 4; expected++ {
+		region, err := heap.NewRegion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count := heap.RegionCount(); count != expected {
+			t.Errorf("Heap has %d regions after NewRegion, expect %d", count, expected)
+		}
+		if stats := heap.Stats(); stats.Regions != expected {
+			t.Errorf("Stats reads %d regions, expect %d", stats.Regions, expected)
+		}
+		if region.beginFrom != uint64(expected-1)*4096 {
+			t.Errorf("Region #%d begins at %d, expect %d", expected, region.beginFrom, (expected-1)*4096)
+		}
+	}
+	if _, err := heap.NewRegion(); !errors.Is(err, ErrHeapFull) {
+		t.Errorf("Expect ErrHeapFull when all content blocks are regions, got %v", err)
+	}
+}
+
+func TestEachRegion(t *testing.T) {
+	// 9 int32 monos fit into a region.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 64, NumberRegions: 8})
+	for i := int32(0); i < 20; i++ {
+		allocateTestInt32(t, heap, i)
+	}
+
+	visited := 0
+	used := uint32(0)
+	err := heap.EachRegion(func(index int, region *Region) error {
+		if region.beginFrom != uint64(index)*64 {
+			t.Errorf("Region #%d begins at %d, expect %d", index, region.beginFrom, index*64)
+		}
+		visited++
+		used += region.UsedBytes()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited != 3 {
+		t.Errorf("Visited %d regions, expect %d", visited, 3)
+	}
+	if used != 20*6 {
+		t.Errorf("Visited regions use %d bytes, expect %d", used, 20*6)
+	}
+}
+
+func TestFetchMonoFromUnusedContent(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocateTestInt32(t, heap, 1)
+
+	// The second content block is not a region yet.
+	if _, err := heap.FetchMono(4096 + 5); err == nil {
+		t.Errorf("Expect error when fetching a mono from an unused content block")
+	}
+	if count := heap.RegionCount(); count != 1 {
+		t.Errorf("Heap has %d regions after fetching, expect %d", count, 1)
+	}
+	region, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region.beginFrom != 4096 {
+		t.Errorf("New region begins at %d, expect %d", region.beginFrom, 4096)
+	}
+}
+
+func TestRegionOf(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for i := 0; i < 2; i++ {
+		if _, err := heap.NewRegion(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		addr        address
+		regionBegin address
+		at          offset
+	}{
+		{0, 0, 0},
+		// The last byte of region #0.
+		{4095, 0, 4095},
+		// The first byte of region #1.
+		{4096, 4096, 0},
+		{4096 + 5, 4096, 5},
+		{2*4096 - 1, 4096, 4095},
+	} {
+		region, at, err := heap.RegionOf(c.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if region.beginFrom != c.regionBegin || at != c.at {
+			t.Errorf("Address %d is at offset %d of the region at %d, expect %d of %d",
+				c.addr, at, region.beginFrom, c.at, c.regionBegin)
+		}
+	}
+	// Region #2 is not handed out yet.
+	if _, _, err := heap.RegionOf(2 * 4096); err == nil {
+		t.Errorf("Expect error when resolving an address in an unused content block")
+	}
+}
@@ -0,0 +1,299 @@
+package heap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+var ErrorMessageJSONCycle = "Cannot write JSON for a cycle at: %d"
+var ErrorMessageCannotWriteJSONKind = "Cannot write JSON for mono of kind: %d"
+var ErrorMessageBadJSON = "Cannot read JSON: %s"
+
+// Write the value at the address as JSON to `w`, like `JSON.stringify` in JavaScript:
+//
+// - int32, uint16, int64 and float64 are numbers; NaN and infinities are null
+// - bools are true or false; null and undefined are null
+// - strings are strings
+// - arrays are arrays, and objects are objects keyed by property names, in the order of Keys
+//
+// A mono shared by different parts of the value is written once for each part.
+// A cycle, like an array contains itself, cannot be written, so it is an error,
+// and what has been written to `w` before it is incomplete. So is a value nested
+// deeper than HeapConfig.MaxDepth.
+func (heap *Heap) ToJSON(root address, w io.Writer) error {
+	return heap.writeJSON(root, w, map[address]bool{})
+}
+
+// The `path` is monos being written from the root to here.
+func (heap *Heap) writeJSON(addr address, w io.Writer, path map[address]bool) error {
+	if path[addr] {
+		return errors.New(fmt.Sprintf(ErrorMessageJSONCycle, addr))
+	}
+	if len(path) >= heap.maxDepth {
+		return errors.New(fmt.Sprintf(ErrorMessageTooDeep, heap.maxDepth))
+	}
+	mono, err := heap.FetchMono(addr)
+	if err != nil {
+		return err
+	}
+	region := mono.region
+
+	switch mono.kind {
+	case MONO_INT32:
+		i, err := region.ReadInt32(mono.valueFromOffset)
+		if err != nil {
+			return err
+		}
+		return writeJSONRaw(w, strconv.FormatInt(int64(i), 10))
+	case MONO_UINT16:
+		i, err := region.ReadUint16(mono.valueFromOffset)
+		if err != nil {
+			return err
+		}
+		return writeJSONRaw(w, strconv.FormatUint(uint64(i), 10))
+	case MONO_INT64:
+		i, err := region.ReadInt64(mono.valueFromOffset)
+		if err != nil {
+			return err
+		}
+		return writeJSONRaw(w, strconv.FormatInt(i, 10))
+	case MONO_FLOAT64:
+		f, err := region.ReadFloat64(mono.valueFromOffset)
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return writeJSONRaw(w, "null")
+		}
+		return writeJSONValue(w, f)
+	case MONO_BOOL:
+		b, err := NewWrappedBool(mono).ReadValue()
+		if err != nil {
+			return err
+		}
+		return writeJSONValue(w, b)
+	case MONO_NULL, MONO_UNDEFINED:
+		return writeJSONRaw(w, "null")
+	case MONO_ADDRESS:
+		pointer, err := region.ReadAddress(mono.valueFromOffset)
+		if err != nil {
+			return err
+		}
+		path[addr] = true
+		defer delete(path, addr)
+		return heap.writeJSON(pointer, w, path)
+	case MONO_STRING_S8:
+		ws, err := NewWrappedString(mono)
+		if err != nil {
+			return err
+		}
+		s, err := ws.ReadGoString()
+		if err != nil {
+			return err
+		}
+		return writeJSONValue(w, s)
+	case MONO_ARRAY_S8:
+		wa, err := NewWrappedArray(mono)
+		if err != nil {
+			return err
+		}
+		elements, err := wa.ToSlice()
+		if err != nil {
+			return err
+		}
+		path[addr] = true
+		defer delete(path, addr)
+		if err := writeJSONRaw(w, "["); err != nil {
+			return err
+		}
+		for i, element := range elements {
+			if i > 0 {
+				if err := writeJSONRaw(w, ","); err != nil {
+					return err
+				}
+			}
+			if err := heap.writeJSON(element.beginFrom, w, path); err != nil {
+				return err
+			}
+		}
+		return writeJSONRaw(w, "]")
+	case MONO_OBJECT_S8:
+		wo, err := NewWrappedObject(mono)
+		if err != nil {
+			return err
+		}
+		keys, err := wo.Keys()
+		if err != nil {
+			return err
+		}
+		path[addr] = true
+		defer delete(path, addr)
+		if err := writeJSONRaw(w, "{"); err != nil {
+			return err
+		}
+		for i, key := range keys {
+			if i > 0 {
+				if err := writeJSONRaw(w, ","); err != nil {
+					return err
+				}
+			}
+			if err := writeJSONValue(w, key); err != nil {
+				return err
+			}
+			if err := writeJSONRaw(w, ":"); err != nil {
+				return err
+			}
+			value, err := wo.Get(key)
+			if err != nil {
+				return err
+			}
+			if err := heap.writeJSON(value.beginFrom, w, path); err != nil {
+				return err
+			}
+		}
+		return writeJSONRaw(w, "}")
+	}
+	return errors.New(fmt.Sprintf(ErrorMessageCannotWriteJSONKind, mono.kind))
+}
+
+// Read a JSON value from `r`, and allocate monos for it, like `JSON.parse` in JavaScript:
+//
+// - numbers are float64, since JSON has no integers
+// - true and false are bools, and null is null
+// - strings are strings
+// - arrays are arrays, and objects are objects with properties in the order they are read
+//
+// Arrays and objects are allocated before their elements and properties, which are
+// allocated depth-first, and appended or set as they are read.
+// Return the address of the mono for the whole value.
+//
+// Only one JSON value is read. Anything after it is an error, and so is a value
+// nested deeper than HeapConfig.MaxDepth.
+func (heap *Heap) FromJSON(r io.Reader) (address, error) {
+	decoder := json.NewDecoder(r)
+	mono, err := heap.readJSON(decoder, 0)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return 0, errors.New(fmt.Sprintf(ErrorMessageBadJSON, "more than one value"))
+	}
+	return mono.beginFrom, nil
+}
+
+// Read the next value from the decoder, and allocate monos for it.
+// `depth` is how many arrays and objects the value is in.
+func (heap *Heap) readJSON(decoder *json.Decoder, depth int) (*Mono, error) {
+	if depth >= heap.maxDepth {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageTooDeep, heap.maxDepth))
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageBadJSON, err.Error()))
+	}
+	allocator := heap.allocator
+	switch value := token.(type) {
+	case float64:
+		wf, err := allocator.Float64(value)
+		if err != nil {
+			return nil, err
+		}
+		return wf.mono, nil
+	case bool:
+		wb, err := allocator.Bool(value)
+		if err != nil {
+			return nil, err
+		}
+		return wb.mono, nil
+	case nil:
+		return allocator.Null()
+	case string:
+		ws, err := allocator.String(value)
+		if err != nil {
+			return nil, err
+		}
+		return ws.mono, nil
+	case json.Delim:
+		if value == '[' {
+			return heap.readJSONArray(decoder, depth)
+		}
+		if value == '{' {
+			return heap.readJSONObject(decoder, depth)
+		}
+	}
+	return nil, errors.New(fmt.Sprintf(ErrorMessageBadJSON, fmt.Sprintf("unexpected %v", token)))
+}
+
+// Read elements until the closing `]`.
+func (heap *Heap) readJSONArray(decoder *json.Decoder, depth int) (*Mono, error) {
+	wa, err := heap.allocator.Array()
+	if err != nil {
+		return nil, err
+	}
+	for decoder.More() {
+		element, err := heap.readJSON(decoder, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if err := wa.Append(element); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageBadJSON, err.Error()))
+	}
+	return wa.mono, nil
+}
+
+// Read properties until the closing `}`.
+func (heap *Heap) readJSONObject(decoder *json.Decoder, depth int) (*Mono, error) {
+	wo, err := heap.allocator.Object()
+	if err != nil {
+		return nil, err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf(ErrorMessageBadJSON, err.Error()))
+		}
+		// The decoder only gives strings as keys.
+		name := token.(string)
+		value, err := heap.readJSON(decoder, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if err := wo.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageBadJSON, err.Error()))
+	}
+	return wo.mono, nil
+}
+
+// Write the Go value encoded as JSON, for strings to be quoted and escaped,
+// and floats to be in their shortest form. Unlike json.Marshal, `<`, `>` and `&`
+// are not escaped, like `JSON.stringify` doesn't.
+func writeJSONValue(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline.
+	_, err := w.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return err
+}
+
+// Write JSON punctuation, or a number or literal already formatted.
+func writeJSONRaw(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s)
+	return err
+}
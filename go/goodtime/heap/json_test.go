@@ -0,0 +1,95 @@
+package heap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 8})
+
+	// {a: 1, b: [2, 3]}
+	wo := allocateTestObject(t, heap)
+	if err := wo.Set("a", allocateTestInt32(t, heap, 1)); err != nil {
+		t.Fatal(err)
+	}
+	wa := allocateTestArray(t, heap, allocateTestInt32(t, heap, 2), allocateTestInt32(t, heap, 3))
+	if err := wo.Set("b", wa.mono); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := heap.ToJSON(wo.mono.beginFrom, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"a":1,"b":[2,3]}`; buf.String() != expected {
+		t.Errorf("JSON reads %s, expect %s", buf.String(), expected)
+	}
+
+	// Scalars other than int32, and a string to be escaped.
+	ws, err := heap.allocator.String(`say "hi" & <bye>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scalars := allocateTestArray(t, heap,
+		allocateTestFloat64(t, heap, 2.5),
+		allocateMono(t, heap, MONO_NULL),
+		allocateMono(t, heap, MONO_BOOL),
+		ws.mono,
+	)
+	buf.Reset()
+	if err := heap.ToJSON(scalars.mono.beginFrom, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[2.5,null,false,"say \"hi\" & <bye>"]`; buf.String() != expected {
+		t.Errorf("JSON reads %s, expect %s", buf.String(), expected)
+	}
+
+	// A shared array is written twice, but a cycle is an error.
+	shared := allocateTestArray(t, heap, wa.mono, wa.mono)
+	buf.Reset()
+	if err := heap.ToJSON(shared.mono.beginFrom, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[[2,3],[2,3]]`; buf.String() != expected {
+		t.Errorf("JSON reads %s, expect %s", buf.String(), expected)
+	}
+	if err := wa.Append(wa.mono); err != nil {
+		t.Fatal(err)
+	}
+	if err := heap.ToJSON(wa.mono.beginFrom, &bytes.Buffer{}); err == nil {
+		t.Errorf("Expect error when writing JSON for a cycle")
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 16})
+
+	for _, source := range []string{
+		`{"a":1,"b":[2,3.5,{"c":"goodtime","d":[]}],"e":{},"f":null,"g":true}`,
+		`[[[1]],"x",-0.25]`,
+		`"only a string"`,
+	} {
+		root, err := heap.FromJSON(strings.NewReader(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := heap.ToJSON(root, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != source {
+			t.Errorf("JSON after a round trip reads %s, expect %s", buf.String(), source)
+		}
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after reading JSON: %v", err)
+	}
+
+	for _, bad := range []string{`[1,`, `{"a":}`, `1 2`, ``} {
+		if _, err := heap.FromJSON(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expect error when reading JSON %q", bad)
+		}
+	}
+}
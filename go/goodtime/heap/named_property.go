@@ -0,0 +1,155 @@
+package heap
+
+// How many (name, value) pairs a MONO_NAMED_PROPERTY_S8 can contain.
+const MONO_NAMED_PROPERTY_SIZE = 8
+
+// Dictionary of named properties, from name addresses to value addresses.
+// Like chunks for an array, one object's dictionary is a linked list
+// of named-property monos:
+//
+// [ #0 - #1 ] is this NamedProperty mono's header
+// [ #2 - #65 ] is 8 pairs of (address to the name string mono, address to the value mono)
+// [ #66 - #69 ] is the address to the next named-property mono, or 0 if there is none
+//
+// A pair with name address 0 is empty, and can be taken by a later Insert.
+type WrappedNamedProperty struct {
+	mono        *Mono
+	atFirstPair offset
+	atToNext    offset
+}
+
+// Error if the mono is not a whole named-property mono inside its region,
+// so the offsets computed from its end cannot wrap around.
+func NewWrappedNamedProperty(mono *Mono) (*WrappedNamedProperty, error) {
+	if err := mono.validateInRegion(); err != nil {
+		return nil, err
+	}
+	return &WrappedNamedProperty{
+		mono:        mono,
+		atFirstPair: mono.valueFromOffset,
+
+		// [#-4 - #-1] is the address (pointer) to next named-property mono.
+		atToNext: mono.endOffset - 4,
+	}, nil
+}
+
+// From the pair index to the region offset of its name address.
+// The value address is the 4 bytes after it.
+func (wp *WrappedNamedProperty) OffsetFromIndex(index uint8) offset {
+	return wp.atFirstPair + uint32(index)*8
+}
+
+// Find the value address of the name address in this dictionary and the next ones.
+// Names are compared by address, so they should be interned to be found
+// by a name string allocated elsewhere. See Heap.Intern.
+func (wp *WrappedNamedProperty) Lookup(nameAddr address) (address, bool, error) {
+	if nameAddr == 0 {
+		return 0, false, nil
+	}
+	var result address
+	found := false
+	err := wp.traversePairs(func(dict *WrappedNamedProperty, at offset, pairName address, pairValue address) (bool, error) {
+		if pairName != nameAddr {
+			return true, nil
+		}
+		result, found = pairValue, true
+		return false, nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return result, found, nil
+}
+
+// Map the name address to the value address.
+// If the name is already in this dictionary or the next ones, its value is overwritten.
+// Otherwise, it takes the first empty pair, and if all dictionaries are full,
+// a new one is allocated and linked after the last.
+func (wp *WrappedNamedProperty) Insert(nameAddr address, valueAddr address) error {
+	var target *WrappedNamedProperty
+	var atTarget offset
+	var last *WrappedNamedProperty
+	err := wp.traversePairs(func(dict *WrappedNamedProperty, at offset, pairName address, pairValue address) (bool, error) {
+		last = dict
+		if pairName == nameAddr {
+			target, atTarget = dict, at
+			return false, nil
+		}
+		if pairName == 0 && target == nil {
+			target, atTarget = dict, at
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if target == nil {
+		newDict, err := wp.mono.region.heap.allocator.NamedProperty()
+		if err != nil {
+			return err
+		}
+		if err := last.WriteNext(newDict.mono.beginFrom); err != nil {
+			return err
+		}
+		target, atTarget = newDict, newDict.OffsetFromIndex(0)
+	}
+	if err := target.mono.region.WriteAddressBarrier(atTarget, nameAddr); err != nil {
+		return err
+	}
+	return target.mono.region.WriteAddressBarrier(atTarget+4, valueAddr)
+}
+
+func (wp *WrappedNamedProperty) WriteNext(pointerToNext address) error {
+	return wp.mono.region.WriteAddressBarrier(wp.atToNext, pointerToNext)
+}
+
+// Return nil if there is no next named-property mono.
+func (wp *WrappedNamedProperty) FetchNext() (*WrappedNamedProperty, error) {
+	pointerNext, err := wp.mono.region.ReadAddress(wp.atToNext)
+	if err != nil {
+		return nil, err
+	}
+	// Address 0 is the region header, so no mono can be there.
+	if pointerNext == 0 {
+		return nil, nil
+	}
+	monoNext, err := wp.mono.region.heap.FetchMono(pointerNext)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrappedNamedProperty(monoNext)
+}
+
+// Loop over all pairs in this dictionary and the next ones, including empty pairs,
+// until the callback returns false.
+// The `at` is the region offset of the pair in the `dict`.
+func (wp *WrappedNamedProperty) traversePairs(cb func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error)) error {
+	for dict := wp; dict != nil; {
+		region := dict.mono.region
+		for i := uint8(0); i < MONO_NAMED_PROPERTY_SIZE; i++ {
+			at := dict.OffsetFromIndex(i)
+			nameAddr, err := region.ReadAddress(at)
+			if err != nil {
+				return err
+			}
+			valueAddr, err := region.ReadAddress(at + 4)
+			if err != nil {
+				return err
+			}
+			next, err := cb(dict, at, nameAddr, valueAddr)
+			if err != nil {
+				return err
+			}
+			if !next {
+				return nil
+			}
+		}
+		next, err := dict.FetchNext()
+		if err != nil {
+			return err
+		}
+		dict = next
+	}
+	return nil
+}
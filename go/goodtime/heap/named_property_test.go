@@ -0,0 +1,108 @@
+package heap
+
+import (
+	"testing"
+)
+
+func allocateTestNamedProperty(tb testing.TB, heap *Heap) *WrappedNamedProperty {
+	wp, err := heap.allocator.NamedProperty()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return wp
+}
+
+func TestNamedPropertyLookup(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wp := allocateTestNamedProperty(t, heap)
+	name := allocateMono(t, heap, MONO_STRING_S8)
+	other := allocateMono(t, heap, MONO_STRING_S8)
+	value := allocateTestInt32(t, heap, 1)
+
+	if err := wp.Insert(name.beginFrom, value.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+
+	read, found, err := wp.Lookup(name.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || read != value.beginFrom {
+		t.Errorf("Lookup reads (%d, %v), expect (%d, true)", read, found, value.beginFrom)
+	}
+
+	read, found, err = wp.Lookup(other.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Errorf("Lookup reads (%d, %v) for an absent name, expect not found", read, found)
+	}
+
+	// Empty pairs are not a name.
+	if _, found, err = wp.Lookup(0); err != nil || found {
+		t.Errorf("Lookup reads (%v, %v) for name address 0, expect not found", found, err)
+	}
+
+	// Insert again overwrites.
+	newValue := allocateTestInt32(t, heap, 2)
+	if err := wp.Insert(name.beginFrom, newValue.beginFrom); err != nil {
+		t.Fatal(err)
+	}
+	read, found, err = wp.Lookup(name.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || read != newValue.beginFrom {
+		t.Errorf("Lookup reads (%d, %v) after overwriting, expect (%d, true)", read, found, newValue.beginFrom)
+	}
+}
+
+func TestNamedPropertyOverflow(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wp := allocateTestNamedProperty(t, heap)
+
+	names := []*Mono{}
+	values := []*Mono{}
+	for i := 0; i < MONO_NAMED_PROPERTY_SIZE+1; i++ {
+		name := allocateMono(t, heap, MONO_STRING_S8)
+		value := allocateTestInt32(t, heap, int32(i))
+		if err := wp.Insert(name.beginFrom, value.beginFrom); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		values = append(values, value)
+	}
+
+	next, err := wp.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil {
+		t.Fatal("Expect a second named-property mono after overflow")
+	}
+	nameInNext, err := next.mono.region.ReadAddress(next.OffsetFromIndex(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nameInNext != names[MONO_NAMED_PROPERTY_SIZE].beginFrom {
+		t.Errorf("Second dictionary has name %d first, expect %d", nameInNext, names[MONO_NAMED_PROPERTY_SIZE].beginFrom)
+	}
+	last, err := next.FetchNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != nil {
+		t.Errorf("Expect no third named-property mono, but one is at %d", last.mono.beginFrom)
+	}
+
+	for i, name := range names {
+		read, found, err := wp.Lookup(name.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || read != values[i].beginFrom {
+			t.Errorf("Lookup #%d reads (%d, %v), expect (%d, true)", i, read, found, values[i].beginFrom)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package heap
+
+// Object on the heap. Named properties are not in the object mono itself,
+// but in a chain of named-property dictionaries linked from it:
+//
+// [ #0 - #1 ] is this Object mono's header
+// [ #2 - #65 ] is 8 slots, reserved for in-object properties
+// [ #66 - #69 ] is the address to the first named-property dictionary, or 0 if there is none
+// [ #70 - #73 ] is the address to the next object mono, reserved
+//
+// See WrappedNamedProperty for the dictionary. Unlike WrappedNamedProperty.Lookup,
+// the object compares property names by their content, so a name
+// doesn't have to be the same string mono to be found.
+type WrappedObject struct {
+	mono     *Mono
+	atToDict offset
+	atToNext offset
+}
+
+// Error if the mono is not a whole object mono inside its region,
+// so the offsets computed from its end cannot wrap around.
+func NewWrappedObject(mono *Mono) (*WrappedObject, error) {
+	if err := mono.validateInRegion(); err != nil {
+		return nil, err
+	}
+	return &WrappedObject{
+		mono: mono,
+
+		// [#-8 - #-5] is the address (pointer) to the first dictionary.
+		atToDict: mono.endOffset - 8,
+
+		// [#-4 - #-1] is the address (pointer) to next object mono.
+		atToNext: mono.endOffset - 4,
+	}, nil
+}
+
+func (wo *WrappedObject) Mono() *Mono {
+	return wo.mono
+}
+
+// Return the value mono of the property, or nil if the object doesn't have it.
+func (wo *WrappedObject) Get(name string) (*Mono, error) {
+	var result *Mono
+	err := wo.traversePairs(func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error) {
+		matched, err := wo.isName(nameAddr, name)
+		if err != nil || !matched {
+			return true, err
+		}
+		result, err = wo.mono.region.heap.FetchMono(valueAddr)
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Set the property to point to the value mono.
+// If the object doesn't have the property, the name is interned,
+// and inserted into the dictionary. The object gets its first dictionary here
+// if it has none yet.
+func (wo *WrappedObject) Set(name string, value *Mono) error {
+	var found *WrappedNamedProperty
+	var atFound offset
+	err := wo.traversePairs(func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error) {
+		matched, err := wo.isName(nameAddr, name)
+		if err != nil || !matched {
+			return true, err
+		}
+		found, atFound = dict, at
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Overwrite the value of the existing property.
+	if found != nil {
+		return found.mono.region.WriteAddressBarrier(atFound+4, value.beginFrom)
+	}
+
+	dict, err := wo.FetchDict()
+	if err != nil {
+		return err
+	}
+	if dict == nil {
+		dict, err = wo.mono.region.heap.allocator.NamedProperty()
+		if err != nil {
+			return err
+		}
+		if err := wo.mono.region.WriteAddressBarrier(wo.atToDict, dict.mono.beginFrom); err != nil {
+			return err
+		}
+	}
+	nameAddr, err := wo.mono.region.heap.Intern(name)
+	if err != nil {
+		return err
+	}
+	return dict.Insert(nameAddr, value.beginFrom)
+}
+
+// Return names of all properties, in the order of their pairs in the dictionaries.
+func (wo *WrappedObject) Keys() ([]string, error) {
+	keys := []string{}
+	err := wo.traversePairs(func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error) {
+		if nameAddr == 0 {
+			return true, nil
+		}
+		name, err := wo.readName(nameAddr)
+		if err != nil {
+			return false, err
+		}
+		keys = append(keys, name)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// If the object has the property, like the `in` operator.
+func (wo *WrappedObject) Has(name string) (bool, error) {
+	has := false
+	err := wo.traversePairs(func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error) {
+		matched, err := wo.isName(nameAddr, name)
+		if err != nil {
+			return false, err
+		}
+		has = matched
+		return !matched, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return has, nil
+}
+
+// Remove the property by clearing its pair, so both addresses in it are 0,
+// and return whether the object had it. The cleared pair is re-used by a later Set.
+//
+// Unlike other operations on guest values, this one mutates the object in place:
+// other references to the object see the property removed as well.
+// The name string mono is not freed.
+func (wo *WrappedObject) Delete(name string) (bool, error) {
+	deleted := false
+	err := wo.traversePairs(func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error) {
+		matched, err := wo.isName(nameAddr, name)
+		if err != nil || !matched {
+			return true, err
+		}
+		// The name and value addresses of the pair.
+		if err := dict.mono.region.Zero(at, at+8); err != nil {
+			return false, err
+		}
+		deleted = true
+		return false, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// Return the first dictionary, or nil if the object has no property yet.
+func (wo *WrappedObject) FetchDict() (*WrappedNamedProperty, error) {
+	pointerDict, err := wo.mono.region.ReadAddress(wo.atToDict)
+	if err != nil {
+		return nil, err
+	}
+	if pointerDict == 0 {
+		return nil, nil
+	}
+	monoDict, err := wo.mono.region.heap.FetchMono(pointerDict)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrappedNamedProperty(monoDict)
+}
+
+// Loop over all pairs in all dictionaries, including empty ones, until the callback returns false.
+func (wo *WrappedObject) traversePairs(cb func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error)) error {
+	dict, err := wo.FetchDict()
+	if err != nil || dict == nil {
+		return err
+	}
+	return dict.traversePairs(cb)
+}
+
+func (wo *WrappedObject) readName(nameAddr address) (string, error) {
+	mono, err := wo.mono.region.heap.FetchMono(nameAddr)
+	if err != nil {
+		return "", err
+	}
+	ws, err := NewWrappedString(mono)
+	if err != nil {
+		return "", err
+	}
+	return ws.ReadGoString()
+}
+
+// Names are compared by content. An empty pair matches nothing.
+func (wo *WrappedObject) isName(nameAddr address, name string) (bool, error) {
+	if nameAddr == 0 {
+		return false, nil
+	}
+	read, err := wo.readName(nameAddr)
+	if err != nil {
+		return false, err
+	}
+	return read == name, nil
+}
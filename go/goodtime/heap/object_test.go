@@ -0,0 +1,208 @@
+package heap
+
+import (
+	"fmt"
+	"testing"
+)
+
+func allocateTestObject(tb testing.TB, heap *Heap) *WrappedObject {
+	wo, err := NewWrappedObject(allocateMono(tb, heap, MONO_OBJECT_S8))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return wo
+}
+
+// How many dictionaries are linked from the object.
+func countDicts(tb testing.TB, wo *WrappedObject) int {
+	dicts := map[address]bool{}
+	err := wo.traversePairs(func(dict *WrappedNamedProperty, at offset, nameAddr address, valueAddr address) (bool, error) {
+		dicts[dict.mono.beginFrom] = true
+		return true, nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return len(dicts)
+}
+
+func TestObjectKeysAndHas(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wo := allocateTestObject(t, heap)
+
+	keys, err := wo.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("Keys of an empty object reads %v", keys)
+	}
+
+	for i := int32(0); i < 10; i++ {
+		if err := wo.Set(fmt.Sprintf("key%d", i), allocateTestInt32(t, heap, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if countDicts(t, wo) != 2 {
+		t.Errorf("Object has %d dictionaries, expect %d", countDicts(t, wo), 2)
+	}
+
+	keys, err = wo.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 10 {
+		t.Fatalf("Keys reads %d keys, expect %d", len(keys), 10)
+	}
+	for i, key := range keys {
+		if key != fmt.Sprintf("key%d", i) {
+			t.Errorf("Key #%d reads %q, expect %q", i, key, fmt.Sprintf("key%d", i))
+		}
+	}
+
+	for _, name := range []string{"key0", "key7", "key8", "key9"} {
+		has, err := wo.Has(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Errorf("Expect the object has %q", name)
+		}
+	}
+	has, err := wo.Has("key10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Errorf("Expect the object doesn't have %q", "key10")
+	}
+
+	// Setting an existing key overwrites its value.
+	if err := wo.Set("key9", allocateTestInt32(t, heap, 99)); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = wo.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 10 {
+		t.Errorf("Keys reads %d keys after overwriting, expect %d", len(keys), 10)
+	}
+	value, err := wo.Get("key9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := value.region.ReadInt32(value.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 99 {
+		t.Errorf("Get reads %d, expect %d", read, 99)
+	}
+}
+
+func TestObjectDelete(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	wo := allocateTestObject(t, heap)
+	for i, name := range []string{"foo", "bar", "baz"} {
+		if err := wo.Set(name, allocateTestInt32(t, heap, int32(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := wo.Delete("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Errorf("Expect %q is deleted", "bar")
+	}
+	deleted, err = wo.Delete("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted {
+		t.Errorf("Expect %q is not deleted twice", "bar")
+	}
+
+	keys, err := wo.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "foo" || keys[1] != "baz" {
+		t.Errorf("Keys reads %v after deleting, expect [foo baz]", keys)
+	}
+	value, err := wo.Get("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != nil {
+		t.Errorf("Get reads a mono at %d after deleting, expect nil", value.beginFrom)
+	}
+	value, err = wo.Get("baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := value.region.ReadInt32(value.valueFromOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 2 {
+		t.Errorf("Get reads %d, expect %d", read, 2)
+	}
+
+	// The cleared pair is re-used.
+	if err := wo.Set("qux", allocateTestInt32(t, heap, 3)); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = wo.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[1] != "qux" {
+		t.Errorf("Keys reads %v after setting again, expect [foo qux baz]", keys)
+	}
+}
+
+func TestAllocatorObject(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 256, NumberRegions: 4})
+	// Leave room for the object, but not for its dictionary as well.
+	for heap.allocator.latestRegion() == nil || heap.allocator.latestRegion().FreeBytes() > 100 {
+		allocateTestInt32(t, heap, 1)
+	}
+	full := heap.allocator.latestRegion()
+
+	wo, err := heap.allocator.Object()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := wo.FetchDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dict == nil {
+		t.Fatal("Object has no dictionary linked")
+	}
+	if wo.mono.region.beginFrom == full.beginFrom || dict.mono.region.beginFrom != wo.mono.region.beginFrom {
+		t.Errorf("Object is in the region at %d and its dictionary at %d, expect both in a new region",
+			wo.mono.region.beginFrom, dict.mono.region.beginFrom)
+	}
+
+	value := allocateTestInt32(t, heap, 42)
+	if err := wo.Set("foo", value); err != nil {
+		t.Fatal(err)
+	}
+	got, err := wo.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.beginFrom != value.beginFrom {
+		t.Errorf("Property reads %v, expect the mono at %d", got, value.beginFrom)
+	}
+	if dicts := countDicts(t, wo); dicts != 1 {
+		t.Errorf("Object has %d dictionaries after setting a property, expect %d", dicts, 1)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after allocating an object: %v", err)
+	}
+}
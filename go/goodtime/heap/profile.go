@@ -0,0 +1,50 @@
+package heap
+
+// Allocation site 0 means the mono is not tagged.
+const SITE_NONE = 0
+
+func (heap *Heap) tagSite(addr address, site uint32) {
+	if heap.sites == nil {
+		heap.sites = make(map[address]uint32)
+	}
+	heap.sites[addr] = site
+}
+
+// How many bytes are taken by monos of each allocation site,
+// so a profiler can attribute them to guest source locations.
+//
+// Only tagged monos which still hold their address are counted. Minor GC and
+// compaction move the tags with the monos they keep, and drop the tags of the
+// monos they don't, and Free drops the tag of the freed mono.
+func (heap *Heap) BytesBySite() map[uint32]uint64 {
+	result := make(map[uint32]uint64)
+	for addr, site := range heap.sites {
+		mono, err := heap.FetchMono(addr)
+		if err != nil {
+			// The mono is gone.
+			continue
+		}
+		result[site] += uint64(mono.endOffset - mono.beginOffset)
+	}
+	return result
+}
+
+// How many bytes the monos reachable from the root take, including the root,
+// like how much a guest object retains. A mono shared by different parts of
+// the value is counted once, and so are chunks and dictionaries, as parts of
+// the arrays and objects they are linked from.
+func (heap *Heap) SizeOfGraph(root address) (uint32, error) {
+	live, err := heap.Reachable([]address{root})
+	if err != nil {
+		return 0, err
+	}
+	size := uint32(0)
+	for addr := range live {
+		mono, err := heap.FetchMono(addr)
+		if err != nil {
+			return 0, err
+		}
+		size += mono.endOffset - mono.beginOffset
+	}
+	return size, nil
+}
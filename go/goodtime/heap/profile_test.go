@@ -0,0 +1,58 @@
+package heap
+
+import (
+	"testing"
+)
+
+func TestBytesBySite(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocateAt := func(kind byte, site uint32) {
+		_, err := heap.allocator.AllocateWithSite(kind, site, func(mono *Mono) *interface{} {
+			var wrapped interface{}
+			wrapped = mono
+			return &wrapped
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		allocateAt(MONO_INT32, 1)
+	}
+	allocateAt(MONO_FLOAT64, 2)
+	allocateAt(MONO_ARRAY_S8, 2)
+
+	// Not tagged.
+	allocateMono(t, heap, MONO_INT32)
+
+	bytes := heap.BytesBySite()
+	if len(bytes) != 2 {
+		t.Errorf("Bytes of %d sites, expect %d", len(bytes), 2)
+	}
+	if bytes[1] != 3*6 {
+		t.Errorf("Site #1 takes %d bytes, expect %d", bytes[1], 3*6)
+	}
+	if bytes[2] != 10+45 {
+		t.Errorf("Site #2 takes %d bytes, expect %d", bytes[2], 10+45)
+	}
+}
+
+func TestSizeOfGraph(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	shared := allocateTestArray(t, heap, allocateTestInt32(t, heap, 1), allocateTestInt32(t, heap, 2))
+	foo := allocateTestArray(t, heap, shared.mono)
+	bar := allocateTestArray(t, heap, shared.mono)
+	root := allocateTestArray(t, heap, foo.mono, bar.mono)
+	// Not reachable from the root.
+	allocateTestArray(t, heap, shared.mono)
+
+	size, err := heap.SizeOfGraph(root.mono.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 arrays and 2 int32 monos, with the shared array counted once.
+	if size != 4*45+2*6 {
+		t.Errorf("Size of the graph is %d bytes, expect %d", size, 4*45+2*6)
+	}
+}
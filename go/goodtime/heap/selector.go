@@ -0,0 +1,59 @@
+package heap
+
+// Chooses the region a mono is allocated into, when there is no hole for it
+// left by Heap.Free. See Allocator.SetRegionSelector.
+type RegionSelector interface {
+	// Return the region to allocate `size` bytes into, out of the regions the allocator
+	// allocates into, from the oldest to the latest. Return nil to take a new region.
+	// The region returned must have `size` bytes free; otherwise, the allocation fails
+	// with ErrRegionFull.
+	SelectRegion(regions []*Region, size uint32) *Region
+}
+
+// Allocate into the latest region, until it is full. Bytes left at the end of
+// older regions are not used. It is the default, and the quickest.
+type BumpSelector struct{}
+
+func (BumpSelector) SelectRegion(regions []*Region, size uint32) *Region {
+	if len(regions) == 0 {
+		return nil
+	}
+	latest := regions[len(regions)-1]
+	if !latest.capable(size) {
+		return nil
+	}
+	return latest
+}
+
+// Allocate into the oldest region the mono fits in.
+type FirstFitSelector struct{}
+
+func (FirstFitSelector) SelectRegion(regions []*Region, size uint32) *Region {
+	for _, region := range regions {
+		if region.capable(size) {
+			return region
+		}
+	}
+	return nil
+}
+
+// Allocate into the region with the fewest bytes free the mono fits in,
+// so larger spaces are kept for larger monos.
+type BestFitSelector struct{}
+
+func (BestFitSelector) SelectRegion(regions []*Region, size uint32) *Region {
+	var best *Region
+	for _, region := range regions {
+		if region.capable(size) && (best == nil || region.FreeBytes() < best.FreeBytes()) {
+			best = region
+		}
+	}
+	return best
+}
+
+// Use the selector to choose regions for later allocations. nil means BumpSelector.
+func (a *Allocator) SetRegionSelector(selector RegionSelector) {
+	a.heap.mu.Lock()
+	defer a.heap.mu.Unlock()
+	a.selector = selector
+}
@@ -0,0 +1,84 @@
+package heap
+
+import (
+	"testing"
+)
+
+func TestRegionSelector(t *testing.T) {
+	// Region #0: an object leaves 21 bytes free.
+	// Region #1: two arrays leave 5 bytes free, so an int32 doesn't fit into the latest region.
+	allocateRegions := func() *Heap {
+		heap := NewHeapWithConfig(HeapConfig{RegionSize: 100, NumberRegions: 4})
+		allocateMono(t, heap, MONO_OBJECT_S8)
+		allocateMono(t, heap, MONO_ARRAY_S8)
+		allocateMono(t, heap, MONO_ARRAY_S8)
+		if heap.contentCounter != 2 {
+			t.Fatalf("Heap has %d regions, expect %d", heap.contentCounter, 2)
+		}
+		return heap
+	}
+
+	bump := allocateRegions()
+	mono := allocateMono(t, bump, MONO_INT32)
+	if regionIndex := mono.beginFrom / 100; regionIndex != 2 {
+		t.Errorf("Bump allocates into region #%d, expect a new region #%d", regionIndex, 2)
+	}
+
+	bestFit := allocateRegions()
+	bestFit.Allocator().SetRegionSelector(BestFitSelector{})
+	mono = allocateMono(t, bestFit, MONO_INT32)
+	if regionIndex := mono.beginFrom / 100; regionIndex != 0 {
+		t.Errorf("Best-fit allocates into region #%d, expect the older region #%d", regionIndex, 0)
+	}
+	if bestFit.contentCounter != 2 {
+		t.Errorf("Best-fit takes a new region: %d regions, expect %d", bestFit.contentCounter, 2)
+	}
+	if err := bestFit.Validate(); err != nil {
+		t.Errorf("Heap is invalid after best-fit allocation: %v", err)
+	}
+}
+
+// Record the sizes asked for, and choose regions like FirstFitSelector.
+type recordingSelector struct {
+	sizes  []uint32
+	chosen *Region
+}
+
+func (s *recordingSelector) SelectRegion(regions []*Region, size uint32) *Region {
+	s.sizes = append(s.sizes, size)
+	s.chosen = FirstFitSelector{}.SelectRegion(regions, size)
+	return s.chosen
+}
+
+func TestRegionSelectorObject(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	selector := &recordingSelector{}
+	heap.Allocator().SetRegionSelector(selector)
+	if _, err := heap.allocator.Object(); err != nil {
+		t.Fatal(err)
+	}
+	wo, err := heap.allocator.Object()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The object and its dictionary are chosen a region together.
+	objectSize, _ := monoSizeFromKind(MONO_OBJECT_S8)
+	dictSize, _ := monoSizeFromKind(MONO_NAMED_PROPERTY_S8)
+	if len(selector.sizes) != 2 || selector.sizes[1] != objectSize+dictSize {
+		t.Errorf("Selector is asked for %v bytes, expect %d for each object", selector.sizes, objectSize+dictSize)
+	}
+	if selector.chosen == nil || wo.mono.region.beginFrom != selector.chosen.beginFrom {
+		t.Errorf("Object is in the region from %d, expect the region the selector chooses", wo.mono.region.beginFrom)
+	}
+	dict, err := wo.mono.region.ReadAddress(wo.atToDict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dict/4096 != wo.mono.beginFrom/4096 {
+		t.Errorf("Dictionary is at %d, expect it in the region of the object at %d", dict, wo.mono.beginFrom)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after allocating objects: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package heap
+
+// Occupancy of the heap, to tune GC and to spot leaks.
+type HeapStats struct {
+	// How many regions are created on the heap. Regions without a kind yet,
+	// like the ones from NewRegion, are only counted here.
+	Regions int
+
+	// How many regions are in each generation, by the region kind byte.
+	EdenRegions     int
+	SurvivorRegions int
+	TenuredRegions  int
+	HumogousRegions int
+
+	// Bytes of all regions created on the heap, used or not.
+	BytesAllocated uint64
+
+	// Bytes taken by monos in all regions, not counting region headers.
+	BytesUsed uint64
+
+	// How many monos are in all regions.
+	Monos uint64
+}
+
+// Collect stats by visiting all regions created on the heap.
+//
+// If a region is broken, like a mono header with an unknown kind, monos
+// after the broken one are not counted.
+func (heap *Heap) Stats() HeapStats {
+	stats := HeapStats{Regions: int(heap.contentCounter)}
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		region := heap.RegionFromContent(i*uint64(heap.regionSize), heap.regionSize, heap.content[i])
+		switch region.kind {
+		case REGION_EDEN:
+			stats.EdenRegions += 1
+		case REGION_SURVIVOR:
+			stats.SurvivorRegions += 1
+		case REGION_TENURED:
+			stats.TenuredRegions += 1
+		case REGION_HUMOGOUS:
+			stats.HumogousRegions += 1
+		}
+		stats.BytesAllocated += uint64(region.size)
+		stats.BytesUsed += uint64(region.UsedBytes())
+		region.traverse(func(mono *Mono) error {
+			stats.Monos += 1
+			return nil
+		})
+	}
+	return stats
+}
@@ -0,0 +1,55 @@
+package heap
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+
+	empty := heap.Stats()
+	if empty != (HeapStats{}) {
+		t.Errorf("Stats of an empty heap: %+v", empty)
+	}
+
+	allocateTestInt32(t, heap, 1)
+	allocateTestFloat64(t, heap, 2.5)
+	if _, err := heap.allocator.Bool(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := heap.allocator.Array(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second region, promoted.
+	tenured, err := heap.NewRegion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tenured.WriteKind(REGION_TENURED); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := heap.Stats()
+	if stats.EdenRegions != 1 {
+		t.Errorf("Stats reads %d eden regions, expect %d", stats.EdenRegions, 1)
+	}
+	if stats.TenuredRegions != 1 {
+		t.Errorf("Stats reads %d tenured regions, expect %d", stats.TenuredRegions, 1)
+	}
+	if stats.SurvivorRegions != 0 || stats.HumogousRegions != 0 {
+		t.Errorf("Stats reads %d survivor and %d humongous regions, expect none",
+			stats.SurvivorRegions, stats.HumogousRegions)
+	}
+	if stats.BytesAllocated != 2*4096 {
+		t.Errorf("Stats reads %d bytes allocated, expect %d", stats.BytesAllocated, 2*4096)
+	}
+	// int32 + float64 + bool + array, see monoSizeFromKind.
+	expectUsed := uint64(6 + 10 + 3 + 45)
+	if stats.BytesUsed != expectUsed {
+		t.Errorf("Stats reads %d bytes used, expect %d", stats.BytesUsed, expectUsed)
+	}
+	if stats.Monos != 4 {
+		t.Errorf("Stats reads %d monos, expect %d", stats.Monos, 4)
+	}
+}
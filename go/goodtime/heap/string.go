@@ -0,0 +1,327 @@
+package heap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrorMessageStringImmutable = "String is immutable: cannot write to a string already written"
+var ErrorMessageStringChainTooShort = "String chain ends before its length: %d bytes missing"
+var ErrorMessageSubstringOutOfRange = "Substring out of range: [%d, %d) vs. length %d"
+
+// How many bytes a MONO_STRING_S8 can contain (8 slots * 8 bytes).
+const MONO_STRING_SIZE = 64
+
+// String on the heap. Bytes are in the mono until the last 4 bytes,
+// which are the address (pointer) to the next string mono:
+//
+// [ #0 - #1 ] is this String mono's header
+// [ #2 - #5 ] is the length of the string from this mono on, in bytes (uint32)
+// [ #6 - #69 ] is the string bytes, padded with 0 if the string is shorter
+// [ #70 - #73 ] is the address to the next string mono, or 0 if there is none
+//
+// A string longer than 64 bytes is a chain of string monos, each but the last one full.
+// Each mono in the chain has the length of the rest of the string, so it is a string
+// by itself as well.
+//
+// Guest strings are immutable, so a string can only be written once after it is allocated.
+// Then, a substring doesn't need to copy the bytes: it can be a view on the same string.
+// See WrappedStringView.
+type WrappedString struct {
+	mono     *Mono
+	atLength offset
+	atBytes  offset
+	atToNext offset
+}
+
+// Error if the mono is not a whole string mono inside its region,
+// so the offsets computed from its end cannot wrap around.
+func NewWrappedString(mono *Mono) (*WrappedString, error) {
+	if err := mono.validateInRegion(); err != nil {
+		return nil, err
+	}
+	return &WrappedString{
+		mono:     mono,
+		atLength: mono.valueFromOffset,
+		atBytes:  mono.valueFromOffset + 4,
+
+		// [#-4 - #-1] is the address (pointer) to next string mono
+		atToNext: mono.endOffset - 4,
+	}, nil
+}
+
+// Return the address of the string mono for `s`, allocating it only if `s`
+// is not interned yet. So equal strings interned are the same mono, like property names
+// and repeated string literals, and can be compared by address.
+//
+// Interned strings must not be written again, which is already true since strings are immutable.
+//
+// Interning is safe from goroutines. The lock is not held while allocating, so if another
+// goroutine interns `s` meanwhile, its mono is returned, and the one allocated here is garbage.
+func (heap *Heap) Intern(s string) (address, error) {
+	heap.mu.Lock()
+	addr, ok := heap.interned[s]
+	heap.mu.Unlock()
+	if ok {
+		return addr, nil
+	}
+	ws, err := heap.allocator.String(s)
+	if err != nil {
+		return 0, err
+	}
+
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+	if addr, ok := heap.interned[s]; ok {
+		return addr, nil
+	}
+	if heap.interned == nil {
+		heap.interned = make(map[string]address)
+	}
+	heap.interned[s] = ws.mono.beginFrom
+	return ws.mono.beginFrom, nil
+}
+
+func (ws *WrappedString) Mono() *Mono {
+	return ws.mono
+}
+
+// Write bytes to the newly allocated string. Bytes over MONO_STRING_SIZE are
+// written to new string monos, linked after this one.
+// Error if the string has been written, since strings are immutable.
+func (ws *WrappedString) WriteBytes(bs []byte) error {
+	written, err := ws.isWritten()
+	if err != nil {
+		return err
+	}
+	if written {
+		return errors.New(ErrorMessageStringImmutable)
+	}
+	if err := ws.mono.region.WriteUint32(ws.atLength, uint32(len(bs))); err != nil {
+		return err
+	}
+	rest := []byte{}
+	if len(bs) > MONO_STRING_SIZE {
+		bs, rest = bs[:MONO_STRING_SIZE], bs[MONO_STRING_SIZE:]
+	}
+	if _, err := ws.mono.region.WriteString(ws.atBytes, string(bs)); err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	next, err := ws.mono.region.heap.allocator.String(string(rest))
+	if err != nil {
+		return err
+	}
+	return ws.mono.region.WriteAddressBarrier(ws.atToNext, next.mono.beginFrom)
+}
+
+// A fresh string mono is all 0. An empty string is never known as written,
+// but writing it again changes nothing either.
+func (ws *WrappedString) isWritten() (bool, error) {
+	length, err := ws.Length()
+	if err != nil {
+		return false, err
+	}
+	return length != 0, nil
+}
+
+// How many bytes the string has, following the chain. It is not how many runes
+// (characters): a UTF-8 string like "日本" is 6 bytes.
+func (ws *WrappedString) Length() (uint32, error) {
+	return ws.mono.region.ReadUint32(ws.atLength)
+}
+
+// The byte at the index of the string, following the chain to the mono it is in.
+// Like Length, it is by bytes, not by runes.
+func (ws *WrappedString) CharAt(idx uint32) (byte, error) {
+	length, err := ws.Length()
+	if err != nil {
+		return 0, err
+	}
+	if idx >= length {
+		return 0, fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length)
+	}
+	segment := ws
+	for i := uint32(0); i < idx/MONO_STRING_SIZE; i++ {
+		segment, err = segment.FetchNext()
+		if err != nil {
+			return 0, err
+		}
+		if segment == nil {
+			return 0, fmt.Errorf(ErrorMessageIndexOutOfRange, ErrIndexOutOfRange, idx, length)
+		}
+	}
+	return segment.mono.region.ReadByte(segment.atBytes + idx%MONO_STRING_SIZE)
+}
+
+// Read all bytes of the string, following the next string monos.
+func (ws *WrappedString) ReadBytes() ([]byte, error) {
+	length, err := ws.Length()
+	if err != nil {
+		return nil, err
+	}
+	return ws.readRange(0, length)
+}
+
+// Read `n` bytes of the string from the index `start`, which must be in the string.
+func (ws *WrappedString) readRange(start, n uint32) ([]byte, error) {
+	result := make([]byte, 0, n)
+	segment := ws
+	var err error
+	for i := uint32(0); i < start/MONO_STRING_SIZE && segment != nil; i++ {
+		if segment, err = segment.FetchNext(); err != nil {
+			return nil, err
+		}
+	}
+	at := start % MONO_STRING_SIZE
+	for segment != nil && uint32(len(result)) < n {
+		count := n - uint32(len(result))
+		if count > MONO_STRING_SIZE-at {
+			count = MONO_STRING_SIZE - at
+		}
+		s, err := segment.mono.region.ReadString(segment.atBytes+at, count)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s...)
+		at = 0
+		if segment, err = segment.FetchNext(); err != nil {
+			return nil, err
+		}
+	}
+	if uint32(len(result)) < n {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageStringChainTooShort, n-uint32(len(result))))
+	}
+	return result, nil
+}
+
+func (ws *WrappedString) ReadGoString() (string, error) {
+	bs, err := ws.ReadBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// Decode the string from UTF-8 to runes, like for a string of the interpreter.
+// Bytes not in UTF-8 are decoded as U+FFFD each.
+func (ws *WrappedString) Runes() ([]rune, error) {
+	s, err := ws.ReadGoString()
+	if err != nil {
+		return nil, err
+	}
+	return []rune(s), nil
+}
+
+// Return nil if there is no next string mono.
+func (ws *WrappedString) FetchNext() (*WrappedString, error) {
+	pointerNext, err := ws.mono.region.ReadAddress(ws.atToNext)
+	if err != nil {
+		return nil, err
+	}
+	if pointerNext == 0 {
+		return nil, nil
+	}
+	monoNext, err := ws.mono.region.heap.FetchMono(pointerNext)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrappedString(monoNext)
+}
+
+// Allocate a new string of the bytes of this string followed by the bytes of `other`.
+// Both strings are not changed, since strings are immutable.
+func (ws *WrappedString) Concat(other *WrappedString) (*WrappedString, error) {
+	bs, err := ws.ReadBytes()
+	if err != nil {
+		return nil, err
+	}
+	otherBytes, err := other.ReadBytes()
+	if err != nil {
+		return nil, err
+	}
+	return ws.mono.region.heap.allocator.String(string(append(bs, otherBytes...)))
+}
+
+// If the strings have the same bytes, even if they are different monos.
+// Strings of different lengths are not compared byte by byte.
+func (ws *WrappedString) Equals(other *WrappedString) (bool, error) {
+	length, err := ws.Length()
+	if err != nil {
+		return false, err
+	}
+	otherLength, err := other.Length()
+	if err != nil {
+		return false, err
+	}
+	if length != otherLength {
+		return false, nil
+	}
+	// Each segment but the last one is full, so segments of both are aligned.
+	for a, b := ws, other; length > 0; {
+		n := length
+		if n > MONO_STRING_SIZE {
+			n = MONO_STRING_SIZE
+		}
+		if !bytes.Equal(a.mono.region.content[a.atBytes:a.atBytes+n],
+			b.mono.region.content[b.atBytes:b.atBytes+n]) {
+			return false, nil
+		}
+		length -= n
+		if length == 0 {
+			break
+		}
+		if a, err = a.FetchNext(); err != nil {
+			return false, err
+		}
+		if b, err = b.FetchNext(); err != nil {
+			return false, err
+		}
+		if a == nil || b == nil {
+			return false, errors.New(fmt.Sprintf(ErrorMessageStringChainTooShort, length))
+		}
+	}
+	return true, nil
+}
+
+// Bytes in [start, end) of the string, as a view sharing the storage with this string.
+// Nothing is allocated on the heap, and no bytes are copied until the view is read.
+func (ws *WrappedString) Substring(start, end uint32) (*WrappedStringView, error) {
+	length, err := ws.Length()
+	if err != nil {
+		return nil, err
+	}
+	if start > end || end > length {
+		return nil, errors.New(fmt.Sprintf(ErrorMessageSubstringOutOfRange, start, end, length))
+	}
+	return &WrappedStringView{
+		parent: ws,
+		start:  start,
+		length: end - start,
+	}, nil
+}
+
+// A substring which shares the storage with its parent string.
+// This is safe only because strings are immutable.
+type WrappedStringView struct {
+	parent *WrappedString
+	start  uint32
+	length uint32
+}
+
+// Read bytes of the view from the parent string. String monos before the view
+// are skipped by their next pointers, like CharAt does, without reading their bytes.
+func (view *WrappedStringView) ReadBytes() ([]byte, error) {
+	return view.parent.readRange(view.start, view.length)
+}
+
+func (view *WrappedStringView) ReadGoString() (string, error) {
+	bs, err := view.ReadBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
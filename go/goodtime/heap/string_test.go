@@ -0,0 +1,306 @@
+package heap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringImmutable(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	ws, err := heap.allocator.String("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteBytes([]byte("bar")); err == nil {
+		t.Errorf("Expect error when writing to a written string")
+	}
+	s, err := ws.ReadGoString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "foo" {
+		t.Errorf("String reads %q, expect %q", s, "foo")
+	}
+}
+
+func TestStringChaining(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	ws, err := heap.allocator.String(strings.Repeat("a", 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := 0
+	for segment := ws; segment != nil; segment, err = segment.FetchNext() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments += 1
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments != 4 {
+		t.Errorf("String of 200 bytes has %d segments, expect %d", segments, 4)
+	}
+
+	for _, s := range []string{strings.Repeat("goodtime", 125), "日本語の文字列"} {
+		ws, err := heap.allocator.String(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ws.ReadGoString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read != s {
+			t.Errorf("String of %d bytes reads %d bytes: %q", len(s), len(read), read)
+		}
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after chaining strings: %v", err)
+	}
+}
+
+func TestStringLengthAndCharAt(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	s := strings.Repeat("0123456789", 20)
+	ws, err := heap.allocator.String(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, err := ws.Length()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 200 {
+		t.Errorf("Length reads %d, expect %d", length, 200)
+	}
+
+	// In the first segment, and in the third one.
+	for _, idx := range []uint32{3, 131} {
+		b, err := ws.CharAt(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b != s[idx] {
+			t.Errorf("CharAt(%d) reads %q, expect %q", idx, b, s[idx])
+		}
+	}
+	if _, err := ws.CharAt(200); err == nil {
+		t.Errorf("Expect error when the index is out of range")
+	}
+
+	// Bytes, not runes.
+	utf8, err := heap.allocator.String("日本")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length, _ := utf8.Length(); length != 6 {
+		t.Errorf("Length of a UTF-8 string reads %d, expect %d", length, 6)
+	}
+}
+
+func TestStringConcat(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	a := strings.Repeat("a", 50)
+	b := strings.Repeat("b", 30)
+	wa, err := heap.allocator.String(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb, err := heap.allocator.String(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	concat, err := wa.Concat(wb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := concat.ReadGoString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != a+b {
+		t.Errorf("Concat reads %q, expect %q", s, a+b)
+	}
+	// Across the boundary of the first segment.
+	for _, idx := range []uint32{63, 64} {
+		if c, _ := concat.CharAt(idx); c != 'b' {
+			t.Errorf("CharAt(%d) reads %q, expect %q", idx, c, 'b')
+		}
+	}
+
+	for ws, expected := range map[*WrappedString]string{wa: a, wb: b} {
+		if s, _ := ws.ReadGoString(); s != expected {
+			t.Errorf("String after concat reads %q, expect %q", s, expected)
+		}
+	}
+}
+
+func TestStringEquals(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	allocate := func(s string) *WrappedString {
+		ws, err := heap.allocator.String(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	long := strings.Repeat("goodtime", 20)
+	for _, c := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"foo", "foo", true},
+		{long, long, true},
+		{"", "", true},
+		{long, long[:len(long)-1] + "f", false},
+		{"foo", "fooo", false},
+	} {
+		a, b := allocate(c.a), allocate(c.b)
+		if a.mono.beginFrom == b.mono.beginFrom {
+			t.Fatalf("Strings are the same mono at %d", a.mono.beginFrom)
+		}
+		equals, err := a.Equals(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if equals != c.expected {
+			t.Errorf("Equals of %q and %q reads %v, expect %v", c.a, c.b, equals, c.expected)
+		}
+	}
+}
+
+func TestStringSubstring(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	ws, err := heap.allocator.String("Hello, goodtime!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := ws.mono.region.counter
+
+	view, err := ws.Substring(7, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.parent.mono.beginFrom != ws.mono.beginFrom {
+		t.Errorf("View points to %d, expect sharing %d", view.parent.mono.beginFrom, ws.mono.beginFrom)
+	}
+	if ws.mono.region.counter != counter {
+		t.Errorf("Substring allocates %d bytes, expect none", ws.mono.region.counter-counter)
+	}
+	s, err := view.ReadGoString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "goodtime" {
+		t.Errorf("View reads %q, expect %q", s, "goodtime")
+	}
+
+	empty, err := ws.Substring(3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := empty.ReadGoString(); s != "" {
+		t.Errorf("Empty view reads %q", s)
+	}
+	if _, err := ws.Substring(7, 17); err == nil {
+		t.Errorf("Expect error when the substring is out of range")
+	}
+
+	// Views in later string monos, and across them.
+	long := strings.Repeat("0123456789", 20)
+	ws, err = heap.allocator.String(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]uint32{
+		{0, 200},
+		{MONO_STRING_SIZE - 3, MONO_STRING_SIZE + 3},
+		{MONO_STRING_SIZE, 2 * MONO_STRING_SIZE},
+		{2*MONO_STRING_SIZE + 1, 200},
+	} {
+		view, err := ws.Substring(r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := view.ReadGoString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != long[r[0]:r[1]] {
+			t.Errorf("View of [%d, %d) reads %q, expect %q", r[0], r[1], s, long[r[0]:r[1]])
+		}
+	}
+}
+
+func TestHeapIntern(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	foo, err := heap.Intern("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fooAgain, err := heap.Intern("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foo != fooAgain {
+		t.Errorf("Interned %q at %d and %d, expect the same address", "foo", foo, fooAgain)
+	}
+	bar, err := heap.Intern("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bar == foo {
+		t.Errorf("Interned %q and %q at the same address %d", "foo", "bar", foo)
+	}
+
+	// Objects share interned property names.
+	wo := allocateTestObject(t, heap)
+	if err := wo.Set("foo", allocateTestInt32(t, heap, 1)); err != nil {
+		t.Fatal(err)
+	}
+	dict, err := wo.FetchDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, found, err := dict.Lookup(foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || value == 0 {
+		t.Errorf("Lookup by the interned name reads (%d, %v), expect found", value, found)
+	}
+}
+
+func TestStringFromRunes(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	// Longer than a string mono, so the runes cross segments.
+	s := strings.Repeat("good😀time, ", 8)
+
+	ws, err := heap.allocator.StringFromRunes([]rune(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runes, err := ws.Runes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(runes) != s {
+		t.Errorf("Runes read back %q, expect %q", string(runes), s)
+	}
+
+	// The emoji as a UTF-16 surrogate pair.
+	ws, err = heap.allocator.StringFromRunes([]rune{'a', 0xD83D, 0xDE00, 'b'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ws.ReadGoString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != "a😀b" {
+		t.Errorf("Surrogate pair is written as %q, expect %q", read, "a😀b")
+	}
+}
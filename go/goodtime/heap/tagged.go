@@ -0,0 +1,84 @@
+package heap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tagged ints: small integers written into pointer slots as they are, instead of
+// pointing to an int32 mono allocated for each of them.
+//
+// A pointer slot is 32 bits. A slot with the top bit set is a tagged int,
+// and the low 31 bits are the integer, in two's complement:
+//
+// [ 1 | 31-bit int ]
+//
+// Monos may begin at any byte, even at odd addresses, so the low bit cannot be the tag
+// like in many VMs. The top bit can, as long as the heap is not larger than 2GB, so no
+// address of a mono has it set. Since heaps may grow larger, up to 4GB the pointer
+// slots can address, tagged ints are off unless Heap.EnableTaggedInts turns them on,
+// which fails for a heap already over 2GB, and stops Grow from growing it over 2GB.
+// With tagged ints off, an address with the top bit set is of a mono like any other.
+//
+// Tagged ints are not monos: FetchMono fails on them with ErrTaggedInt, and GC,
+// compaction and validation leave them as they are, like 0 for empty slots.
+// Read them from arrays by WrappedArray.IndexAddress, and write them by
+// WrappedArray.AppendAddress.
+
+// The tag bit of an address which is a tagged int.
+const TAG_INT = 1 << 31
+
+// The range of tagged ints: [TAGGED_INT_MIN, TAGGED_INT_MAX].
+const TAGGED_INT_MIN = -1 << 30
+const TAGGED_INT_MAX = 1<<30 - 1
+
+var ErrorMessageTaggedInt = "%w: %#x"
+var ErrorMessageCannotTagInts = "Cannot use tagged ints in a heap over %d bytes: %d regions of %d bytes"
+
+var ErrTaggedInt = errors.New("Address is a tagged int, not a mono")
+
+// Turn on tagged ints, so addresses with the top bit set are tagged ints in this heap.
+// Fail if the heap is over 2GB already. After this, Grow fails instead of growing it over 2GB.
+// A heap loaded by LoadHeap has them off, until they are turned on again.
+func (heap *Heap) EnableTaggedInts() error {
+	heap.mu.Lock()
+	defer heap.mu.Unlock()
+	if !fitsTaggedInts(len(heap.content), heap.regionSize) {
+		return errors.New(fmt.Sprintf(ErrorMessageCannotTagInts, uint64(TAG_INT), len(heap.content), heap.regionSize))
+	}
+	heap.taggedInts = true
+	return nil
+}
+
+// If all addresses of the regions are under the tag bit.
+func fitsTaggedInts(regions int, regionSize uint32) bool {
+	return uint64(regions)*uint64(regionSize) <= TAG_INT
+}
+
+// If the int fits in a tagged int.
+func CanTagInt(i int32) bool {
+	return i >= TAGGED_INT_MIN && i <= TAGGED_INT_MAX
+}
+
+// Tag the int as an address to write into pointer slots.
+// The int must fit (see CanTagInt); otherwise, it wraps around like a 31-bit int.
+// The heap must have tagged ints on (see EnableTaggedInts), or it takes the address
+// as of a mono.
+func (heap *Heap) TagInt(i int32) address {
+	return address(TAG_INT | uint32(i)&(TAG_INT-1))
+}
+
+// Untag the int from the address. Return false if the address is not a tagged int,
+// like an address of a mono, or any address when the heap has tagged ints off.
+func (heap *Heap) UntagInt(addr address) (int32, bool) {
+	if !heap.isTaggedInt(addr) {
+		return 0, false
+	}
+	// Shift the tag out, then back with the sign of the 31-bit int.
+	return int32(uint32(addr)<<1) >> 1, true
+}
+
+// If the address is a tagged int, not an address of a mono.
+func (heap *Heap) isTaggedInt(addr address) bool {
+	return heap.taggedInts && addr>>31 == 1
+}
@@ -0,0 +1,147 @@
+package heap
+
+import (
+	"errors"
+	"testing"
+)
+
+// A heap with tagged ints on.
+func newTestTaggedHeap(t *testing.T, cfg HeapConfig) *Heap {
+	heap := NewHeapWithConfig(cfg)
+	if err := heap.EnableTaggedInts(); err != nil {
+		t.Fatal(err)
+	}
+	return heap
+}
+
+func TestTagInt(t *testing.T) {
+	heap := newTestTaggedHeap(t, HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, i := range []int32{0, 1, -1, 42, TAGGED_INT_MIN, TAGGED_INT_MAX} {
+		untagged, ok := heap.UntagInt(heap.TagInt(i))
+		if !ok || untagged != i {
+			t.Errorf("Untagging tagged %d gives %d (%v), expect %d", i, untagged, ok, i)
+		}
+	}
+	if CanTagInt(TAGGED_INT_MAX+1) || CanTagInt(TAGGED_INT_MIN-1) {
+		t.Errorf("Ints out of 31 bits can be tagged")
+	}
+	mono := allocateTestInt32(t, heap, 1)
+	if _, ok := heap.UntagInt(mono.beginFrom); ok {
+		t.Errorf("Address of a mono at %d is untagged as an int", mono.beginFrom)
+	}
+	if _, err := heap.FetchMono(heap.TagInt(1)); !errors.Is(err, ErrTaggedInt) {
+		t.Errorf("Fetching a tagged int fails with %v, expect %v", err, ErrTaggedInt)
+	}
+}
+
+func TestArrayOfTaggedInts(t *testing.T) {
+	heap := newTestTaggedHeap(t, HeapConfig{RegionSize: 4096, NumberRegions: 8})
+	wa := allocateTestArray(t, heap)
+	for i := int32(0); i < 12; i++ {
+		if err := wa.AppendAddress(heap.TagInt(i - 6)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The array is the only mono: no int32 monos are allocated.
+	if monos := heap.Stats().Monos; monos != 2 {
+		t.Errorf("Heap has %d monos, expect the array and its second chunk", monos)
+	}
+
+	// Tagged ints stay as they are, while the array moves.
+	roots := []address{wa.mono.beginFrom}
+	if _, err := heap.MinorGC(roots); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := heap.Compact(roots); err != nil {
+		t.Fatal(err)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Heap is invalid after GC: %v", err)
+	}
+	mono, err := heap.FetchMono(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa, err = NewWrappedArray(mono)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := uint32(0); idx < 12; idx++ {
+		element, err := wa.IndexAddress(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i, ok := heap.UntagInt(element)
+		if !ok || i != int32(idx)-6 {
+			t.Errorf("Element #%d is %d (%v), expect tagged %d", idx, i, ok, int32(idx)-6)
+		}
+	}
+
+	// Pop cannot return a tagged int as a mono, and keeps it.
+	if _, err := wa.Pop(); !errors.Is(err, ErrTaggedInt) {
+		t.Errorf("Pop of a tagged int fails with %v, expect %v", err, ErrTaggedInt)
+	}
+	for idx := int32(11); idx >= 0; idx-- {
+		element, err := wa.PopAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i, ok := heap.UntagInt(element); !ok || i != idx-6 {
+			t.Errorf("Popped %d (%v), expect tagged %d", i, ok, idx-6)
+		}
+	}
+	if element, err := wa.PopAddress(); element != 0 || err != nil {
+		t.Errorf("Pop from an empty array returns %d, %v, expect 0", element, err)
+	}
+}
+
+func TestTaggedIntsUnder2GB(t *testing.T) {
+	// Blocks of the heap are only counted, so they are left nil instead of taking 2GB.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 1 << 20, NumberRegions: 1})
+	heap.content = make([][]byte, 1<<11)
+	if err := heap.EnableTaggedInts(); err != nil {
+		t.Fatalf("Cannot turn on tagged ints in a heap of 2GB: %v", err)
+	}
+	if err := heap.Grow(1); err == nil {
+		t.Errorf("Expect error when growing a heap with tagged ints over 2GB")
+	}
+
+	heap.taggedInts = false
+	heap.content = make([][]byte, 1<<11+1)
+	if err := heap.EnableTaggedInts(); err == nil {
+		t.Errorf("Expect error when turning on tagged ints in a heap over 2GB")
+	}
+}
+
+func TestFetchMonoOver2GB(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 1 << 20, NumberRegions: 1})
+	// Hand out the blocks before 2GB as if they were used, without allocating them,
+	// so the next region begins from 2GB.
+	blocks := make([][]byte, 1<<11+1)
+	blocks[1<<11] = make([]byte, 1<<20)
+	heap.content = blocks
+	heap.contentCounter = 1 << 11
+
+	element := allocateTestInt32(t, heap, 7)
+	wa := allocateTestArray(t, heap, element)
+	if element.beginFrom < TAG_INT {
+		t.Fatalf("Mono is at %#x, expect an address over 2GB", element.beginFrom)
+	}
+	if _, ok := heap.UntagInt(element.beginFrom); ok {
+		t.Errorf("Address %#x is untagged as an int with tagged ints off", element.beginFrom)
+	}
+	fetched, err := heap.FetchMono(element.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fetched.Equals(element) {
+		t.Errorf("Fetched mono at %#x, expect %#x", fetched.beginFrom, element.beginFrom)
+	}
+	live, err := heap.Reachable([]address{wa.mono.beginFrom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !live[element.beginFrom] {
+		t.Errorf("Element at %#x is not reachable from its array", element.beginFrom)
+	}
+}
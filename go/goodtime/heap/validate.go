@@ -0,0 +1,236 @@
+package heap
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrorMessageInvalidPointer = "Invalid pointer at %d to %d: %s"
+var ErrorMessageInvalidRegion = "Region #%d at offset %d: %s"
+
+// Check the pointer stored at the `from` address points to a mono header:
+// inside a region which is in use, below the region counter, and at the
+// boundary of a mono, not in the middle of one.
+// The `from` address is only for the error message.
+//
+// Address 0 is the header of the first region, so it is invalid as well.
+// Callers should skip empty slots, which are 0, before validating.
+func (heap *Heap) ValidatePointer(from address, to address) error {
+	region, at, err := heap.RegionOf(to)
+	if err != nil {
+		return errors.New(fmt.Sprintf(ErrorMessageInvalidPointer, from, to, "not in a region in use"))
+	}
+	if at < 5 || at >= region.counter {
+		return errors.New(fmt.Sprintf(ErrorMessageInvalidPointer, from, to, "out of the used range of its region"))
+	}
+	kind, err := region.ReadByte(at)
+	if err != nil {
+		return err
+	}
+	if kind == 0 {
+		return errors.New(fmt.Sprintf(ErrorMessageInvalidPointer, from, to, "no mono kind"))
+	}
+	if err := region.validateMonoBoundary(at); err != nil {
+		return errors.New(fmt.Sprintf(ErrorMessageInvalidPointer, from, to, err.Error()))
+	}
+	return nil
+}
+
+// Check the whole heap is consistent, like after a GC, and return the first problem found:
+//
+// - each mono is as large as its kind and inside its region
+// - each pointer slot in monos is 0, a tagged int, or points to a mono header (see ValidatePointer)
+// - each region counter is where the last mono ends
+//
+// The error names the region and the offset of the mono with the problem.
+func (heap *Heap) Validate() error {
+	// Walk all monos first, so a pointer is checked by looking up the headers,
+	// instead of walking its region again like ValidatePointer does.
+	headers := map[address]bool{}
+	monos := []*Mono{}
+	for i := uint64(0); i < heap.contentCounter; i++ {
+		region := heap.RegionFromContent(i*uint64(heap.regionSize), heap.regionSize, heap.content[i])
+
+		// Monos begin after the region counter and kind.
+		end := offset(5)
+		err := region.traverse(func(mono *Mono) error {
+			if err := mono.validateInRegion(); err != nil {
+				return errors.New(fmt.Sprintf(ErrorMessageInvalidRegion, i, mono.beginOffset, err.Error()))
+			}
+			end = mono.endOffset
+			headers[mono.beginFrom] = true
+			monos = append(monos, mono)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if end != region.counter {
+			return errors.New(fmt.Sprintf(ErrorMessageInvalidRegion, i, end,
+				fmt.Sprintf("monos end here, but the region counter is %d", region.counter)))
+		}
+	}
+
+	for _, mono := range monos {
+		region := mono.region
+		regionIndex := region.beginFrom / uint64(heap.regionSize)
+		slots, err := mono.pointerSlots()
+		if err != nil {
+			return errors.New(fmt.Sprintf(ErrorMessageInvalidRegion, regionIndex, mono.beginOffset, err.Error()))
+		}
+		for _, at := range slots {
+			pointer, err := region.ReadAddress(at)
+			if err != nil {
+				return errors.New(fmt.Sprintf(ErrorMessageInvalidRegion, regionIndex, mono.beginOffset, err.Error()))
+			}
+			if pointer == 0 || heap.isTaggedInt(pointer) || headers[pointer] {
+				continue
+			}
+			// Let ValidatePointer tell why it is invalid.
+			err = heap.ValidatePointer(region.beginFrom+uint64(at), pointer)
+			if err == nil {
+				err = errors.New(fmt.Sprintf(ErrorMessageInvalidPointer, region.beginFrom+uint64(at), pointer, "not a mono header"))
+			}
+			return errors.New(fmt.Sprintf(ErrorMessageInvalidRegion, regionIndex, mono.beginOffset, err.Error()))
+		}
+	}
+	return nil
+}
+
+// Non-zero addresses the mono points to, by its kind, like GC follows them:
+//
+// - an address mono, the mono it points to
+// - an array, its elements and the chunks after its default chunk, following the chunks
+// - an object, its first dictionary, and names and values of its properties, following the dictionaries
+// - a string, the next string mono, if the string goes on there
+//
+// Monos of other kinds don't point to anything. Tagged ints in slots are not addresses,
+// so they are not listed.
+func (mono *Mono) References() ([]address, error) {
+	references, _, err := mono.referencesWithParts()
+	return references, err
+}
+
+// Like References, and the addresses of the parts followed, like chunks after the
+// default chunk of an array, which are in the references as well. A walk over
+// references has their slots covered by the mono, so it needs not visit them again.
+func (mono *Mono) referencesWithParts() ([]address, []address, error) {
+	references := []address{}
+	parts := []address{}
+	// Chunks of an array and dictionaries of an object are parts of it,
+	// so their slots are followed as if they were in the mono.
+	for part := mono; part != nil; {
+		slots, err := part.pointerSlots()
+		if err != nil {
+			return nil, nil, err
+		}
+		atToPart, err := part.partSlot()
+		if err != nil {
+			return nil, nil, err
+		}
+		var next *Mono
+		for _, at := range slots {
+			pointer, err := part.region.ReadAddress(at)
+			if err != nil {
+				return nil, nil, err
+			}
+			if pointer == 0 || part.region.heap.isTaggedInt(pointer) {
+				continue
+			}
+			references = append(references, pointer)
+			if at == atToPart {
+				if next, err = part.region.heap.FetchMono(pointer); err != nil {
+					return nil, nil, err
+				}
+				parts = append(parts, pointer)
+			}
+		}
+		part = next
+	}
+	return references, parts, nil
+}
+
+// Region offset of the slot pointing to the next part of the mono, like the next chunk
+// of an array, or 0 if the mono has no parts. Offset 0 is never a slot.
+func (mono *Mono) partSlot() (offset, error) {
+	switch mono.kind {
+	case MONO_ARRAY_S8:
+		wa, err := NewWrappedArray(mono)
+		if err != nil {
+			return 0, err
+		}
+		return wa.defaultChunk.atToNext, nil
+	case MONO_CHUNK_S8:
+		chunk, err := NewWrappedChunk(mono)
+		if err != nil {
+			return 0, err
+		}
+		return chunk.atToNext, nil
+	case MONO_OBJECT_S8:
+		wo, err := NewWrappedObject(mono)
+		if err != nil {
+			return 0, err
+		}
+		return wo.atToDict, nil
+	case MONO_NAMED_PROPERTY_S8:
+		wp, err := NewWrappedNamedProperty(mono)
+		if err != nil {
+			return 0, err
+		}
+		return wp.atToNext, nil
+	}
+	return 0, nil
+}
+
+// Region offsets of the pointer slots in the mono, by its kind.
+// Slots of a chunk are only the ones below its length, plus the next pointer.
+func (mono *Mono) pointerSlots() ([]offset, error) {
+	switch mono.kind {
+	case MONO_ADDRESS:
+		return []offset{mono.valueFromOffset}, nil
+	case MONO_ARRAY_S8:
+		wa, err := NewWrappedArray(mono)
+		if err != nil {
+			return nil, err
+		}
+		return wa.defaultChunk.pointerSlots()
+	case MONO_CHUNK_S8:
+		chunk, err := NewWrappedChunk(mono)
+		if err != nil {
+			return nil, err
+		}
+		return chunk.pointerSlots()
+	case MONO_STRING_S8:
+		return []offset{mono.endOffset - 4}, nil
+	case MONO_OBJECT_S8:
+		wo, err := NewWrappedObject(mono)
+		if err != nil {
+			return nil, err
+		}
+		return []offset{wo.atToDict, wo.atToNext}, nil
+	case MONO_NAMED_PROPERTY_S8:
+		wp, err := NewWrappedNamedProperty(mono)
+		if err != nil {
+			return nil, err
+		}
+		slots := []offset{}
+		for i := uint8(0); i < MONO_NAMED_PROPERTY_SIZE; i++ {
+			at := wp.OffsetFromIndex(i)
+			slots = append(slots, at, at+4)
+		}
+		return append(slots, wp.atToNext), nil
+	}
+	return []offset{}, nil
+}
+
+func (w *WrappedChunk) pointerSlots() ([]offset, error) {
+	length, err := w.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	slots := []offset{}
+	for i := uint8(0); i < length; i++ {
+		slots = append(slots, w.OffsetFromIndex(i))
+	}
+	return append(slots, w.atToNext), nil
+}
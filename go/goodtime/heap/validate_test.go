@@ -0,0 +1,133 @@
+package heap
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidatePointer(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	element := allocateTestFloat64(t, heap, 2.5)
+	wa := allocateTestArray(t, heap, element)
+	chunk := wa.defaultChunk
+	atSlot := chunk.OffsetFromIndex(0)
+	slotAddress := chunk.mono.region.beginFrom + uint64(atSlot)
+
+	if err := heap.ValidatePointer(slotAddress, element.beginFrom); err != nil {
+		t.Errorf("Pointer to a mono header is invalid: %v", err)
+	}
+	arraySize, err := monoSizeFromKind(MONO_ARRAY_S8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []address{
+		// In the middle of the float64.
+		element.beginFrom + 1,
+		// After all monos in the region.
+		wa.mono.beginFrom + uint64(arraySize),
+		// In a region not in use.
+		uint64(heap.regionSize) * 2,
+		// Region header.
+		0,
+	} {
+		if err := chunk.mono.region.WriteAddress(atSlot, bad); err != nil {
+			t.Fatal(err)
+		}
+		pointer, err := chunk.mono.region.ReadAddress(atSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := heap.ValidatePointer(slotAddress, pointer); err == nil {
+			t.Errorf("Expect error when validating a pointer to %d", pointer)
+		}
+	}
+}
+
+func TestHeapValidate(t *testing.T) {
+	// Small regions, so pointers cross regions.
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 512, NumberRegions: 16})
+	if err := heap.Validate(); err != nil {
+		t.Errorf("Empty heap is invalid: %v", err)
+	}
+
+	wa := allocateTestNested(t, heap, 3)
+	wo := allocateTestObject(t, heap)
+	for _, name := range []string{"foo", "bar", "baz"} {
+		if err := wo.Set(name, wa.mono); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := heap.allocator.String("goodtime"); err != nil {
+		t.Fatal(err)
+	}
+	if err := heap.Validate(); err != nil {
+		t.Fatalf("Heap is invalid after allocations: %v", err)
+	}
+
+	// A dangling pointer in the array.
+	chunk := wa.defaultChunk
+	if err := chunk.mono.region.WriteAddress(chunk.OffsetFromIndex(0), wa.mono.beginFrom+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := heap.Validate(); err == nil {
+		t.Errorf("Expect error when validating a heap with a dangling pointer")
+	}
+	if err := chunk.mono.region.WriteAddress(chunk.OffsetFromIndex(0), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A region counter over where monos end.
+	region := wo.mono.region
+	region.counter += 1
+	if err := region.WriteCounter(); err != nil {
+		t.Fatal(err)
+	}
+	if err := heap.Validate(); err == nil {
+		t.Errorf("Expect error when validating a heap with a wrong region counter")
+	}
+}
+
+func TestMonoReferences(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+
+	// More properties than a dictionary holds, so they are in two.
+	wo := allocateTestObject(t, heap)
+	values := []address{}
+	for i := 0; i < MONO_NAMED_PROPERTY_SIZE+2; i++ {
+		value := allocateTestInt32(t, heap, int32(i))
+		if err := wo.Set(fmt.Sprintf("foo%d", i), value); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value.beginFrom)
+	}
+	references, err := wo.mono.References()
+	if err != nil {
+		t.Fatal(err)
+	}
+	referenced := map[address]bool{}
+	for _, reference := range references {
+		referenced[reference] = true
+	}
+	for i, value := range values {
+		if !referenced[value] {
+			t.Errorf("References of the object miss the value of foo%d at %d: %v", i, value, references)
+		}
+	}
+	dict, err := wo.FetchDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !referenced[dict.mono.beginFrom] {
+		t.Errorf("References of the object miss its dictionary at %d: %v", dict.mono.beginFrom, references)
+	}
+
+	// Scalars point to nothing.
+	references, err = allocateTestInt32(t, heap, 1).References()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(references) != 0 {
+		t.Errorf("References of an int32 are %v, expect none", references)
+	}
+}
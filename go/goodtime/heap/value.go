@@ -0,0 +1,156 @@
+package heap
+
+// Bool on the heap:
+//
+// [ #0 - #1 ] is this Bool mono's header
+// [ #2 ] is 1 for true, or 0 for false
+type WrappedBool struct {
+	mono    *Mono
+	atValue offset
+}
+
+func NewWrappedBool(mono *Mono) *WrappedBool {
+	return &WrappedBool{
+		mono:    mono,
+		atValue: mono.valueFromOffset,
+	}
+}
+
+func (w *WrappedBool) Mono() *Mono {
+	return w.mono
+}
+
+func (w *WrappedBool) ReadValue() (bool, error) {
+	b, err := w.mono.region.ReadUint8(w.atValue)
+	if err != nil {
+		return false, err
+	}
+	return b != 0, nil
+}
+
+func (w *WrappedBool) WriteValue(b bool) error {
+	if b {
+		return w.mono.region.WriteUint8(w.atValue, 1)
+	}
+	return w.mono.region.WriteUint8(w.atValue, 0)
+}
+
+// Int32 on the heap:
+//
+// [ #0 - #1 ] is this Int32 mono's header
+// [ #2 - #5 ] is the int32
+//
+// Numbers in monos are in the byte order of their region, like the int32 here,
+// so a region formed by Heap.RegionFromContentWithOrder reads and writes them in its order.
+type WrappedInt32 struct {
+	mono    *Mono
+	atValue offset
+}
+
+func NewWrappedInt32(mono *Mono) *WrappedInt32 {
+	return &WrappedInt32{
+		mono:    mono,
+		atValue: mono.valueFromOffset,
+	}
+}
+
+func (w *WrappedInt32) Mono() *Mono {
+	return w.mono
+}
+
+func (w *WrappedInt32) ReadValue() (int32, error) {
+	return w.mono.region.ReadInt32(w.atValue)
+}
+
+func (w *WrappedInt32) WriteValue(i int32) error {
+	return w.mono.region.WriteInt32(w.atValue, i)
+}
+
+// Float64 on the heap:
+//
+// [ #0 - #1 ] is this Float64 mono's header
+// [ #2 - #9 ] is the float64, by its IEEE 754 bits
+//
+// Bits are kept as they are, so NaN, ±Inf and -0 read back as they were written.
+type WrappedFloat64 struct {
+	mono    *Mono
+	atValue offset
+}
+
+func NewWrappedFloat64(mono *Mono) *WrappedFloat64 {
+	return &WrappedFloat64{
+		mono:    mono,
+		atValue: mono.valueFromOffset,
+	}
+}
+
+func (w *WrappedFloat64) Mono() *Mono {
+	return w.mono
+}
+
+func (w *WrappedFloat64) ReadValue() (float64, error) {
+	return w.mono.region.ReadFloat64(w.atValue)
+}
+
+func (w *WrappedFloat64) WriteValue(f float64) error {
+	return w.mono.region.WriteFloat64(w.atValue, f)
+}
+
+// Int64 on the heap:
+//
+// [ #0 - #1 ] is this Int64 mono's header
+// [ #2 - #9 ] is the int64
+type WrappedInt64 struct {
+	mono    *Mono
+	atValue offset
+}
+
+func NewWrappedInt64(mono *Mono) *WrappedInt64 {
+	return &WrappedInt64{
+		mono:    mono,
+		atValue: mono.valueFromOffset,
+	}
+}
+
+func (w *WrappedInt64) Mono() *Mono {
+	return w.mono
+}
+
+func (w *WrappedInt64) ReadValue() (int64, error) {
+	return w.mono.region.ReadInt64(w.atValue)
+}
+
+func (w *WrappedInt64) WriteValue(i int64) error {
+	return w.mono.region.WriteInt64(w.atValue, i)
+}
+
+// Fetch the mono at the address, and wrap it by its kind, like *WrappedInt32
+// for MONO_INT32, so the host can type-switch on the result instead of the kind.
+// Kinds without a wrapper, like null and undefined, are the *Mono itself.
+func (heap *Heap) FetchWrapped(addr address) (interface{}, error) {
+	mono, err := heap.FetchMono(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch mono.kind {
+	case MONO_INT32:
+		return NewWrappedInt32(mono), nil
+	case MONO_FLOAT64:
+		return NewWrappedFloat64(mono), nil
+	case MONO_INT64:
+		return NewWrappedInt64(mono), nil
+	case MONO_BOOL:
+		return NewWrappedBool(mono), nil
+	case MONO_STRING_S8:
+		return NewWrappedString(mono)
+	case MONO_ARRAY_S8:
+		return NewWrappedArray(mono)
+	case MONO_CHUNK_S8:
+		return NewWrappedChunk(mono)
+	case MONO_OBJECT_S8:
+		return NewWrappedObject(mono)
+	case MONO_NAMED_PROPERTY_S8:
+		return NewWrappedNamedProperty(mono)
+	}
+	return mono, nil
+}
@@ -0,0 +1,252 @@
+package heap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestBool(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, b := range []bool{true, false} {
+		wb, err := heap.allocator.Bool(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched, err := heap.FetchMono(wb.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fetched.kind != MONO_BOOL {
+			t.Errorf("Fetched kind %d, expect %d", fetched.kind, MONO_BOOL)
+		}
+		value, err := NewWrappedBool(fetched).ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != b {
+			t.Errorf("Bool reads %v, expect %v", value, b)
+		}
+	}
+}
+
+func TestNullAndUndefined(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	null, err := heap.allocator.Null()
+	if err != nil {
+		t.Fatal(err)
+	}
+	undefined, err := heap.allocator.Undefined()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Header only, so undefined is right after null.
+	if undefined.beginFrom != null.beginFrom+MONO_HEADER_SIZE {
+		t.Errorf("Undefined at %d, expect %d", undefined.beginFrom, null.beginFrom+MONO_HEADER_SIZE)
+	}
+
+	for _, expected := range []*Mono{null, undefined} {
+		fetched, err := heap.FetchMono(expected.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fetched.kind != expected.kind {
+			t.Errorf("Fetched kind %d, expect %d", fetched.kind, expected.kind)
+		}
+	}
+}
+
+func TestInt64(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, i := range []int64{math.MinInt64, -1, 0, 1 << 40, math.MaxInt64} {
+		wi, err := heap.allocator.Int64(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched, err := heap.FetchMono(wi.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fetched.kind != MONO_INT64 {
+			t.Errorf("Fetched kind %d, expect %d", fetched.kind, MONO_INT64)
+		}
+		value, err := NewWrappedInt64(fetched).ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != i {
+			t.Errorf("Int64 reads %d, expect %d", value, i)
+		}
+	}
+}
+
+func TestInt32(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, i := range []int32{0, 42, -42, math.MaxInt32, math.MinInt32} {
+		wi := NewWrappedInt32(allocateMono(t, heap, MONO_INT32))
+		if err := wi.WriteValue(i); err != nil {
+			t.Fatal(err)
+		}
+		fetched, err := heap.FetchMono(wi.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := NewWrappedInt32(fetched).ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != i {
+			t.Errorf("Int32 reads %d, expect %d", value, i)
+		}
+	}
+}
+
+// Floats to round-trip bit for bit: signed zeros, infinities, the smallest
+// and largest subnormals, and NaNs, quiet or signaling, with payloads.
+var testFloat64s = []float64{
+	math.Pi, -math.Pi, 0, math.Copysign(0, -1), math.Inf(1), math.Inf(-1),
+	math.Float64frombits(1), math.Float64frombits(0x000fffffffffffff), -math.Float64frombits(1),
+	math.NaN(),
+	math.Float64frombits(0x7ff0000000000001), // signaling
+	math.Float64frombits(0x7ff4000000000abc), // signaling with a payload
+	math.Float64frombits(0xfff8000000000123), // quiet and negative with a payload
+}
+
+func TestFloat64(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, f := range testFloat64s {
+		wf := NewWrappedFloat64(allocateMono(t, heap, MONO_FLOAT64))
+		if err := wf.WriteValue(f); err != nil {
+			t.Fatal(err)
+		}
+		fetched, err := heap.FetchMono(wf.mono.beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := NewWrappedFloat64(fetched).ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Compare bits, since NaN != NaN.
+		if math.Float64bits(value) != math.Float64bits(f) {
+			t.Errorf("Float64 reads %v (%x), expect %v (%x)", value, math.Float64bits(value), f, math.Float64bits(f))
+		}
+	}
+}
+
+func TestAllocatorNumbers(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	for _, i := range []int32{0, 7, math.MinInt32} {
+		wi, err := heap.allocator.Int32(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapped, err := heap.FetchWrapped(wi.Mono().beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched, ok := wrapped.(*WrappedInt32)
+		if !ok {
+			t.Fatalf("Int32 mono is fetched as %T, expect %T", wrapped, wi)
+		}
+		value, err := fetched.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != i {
+			t.Errorf("Int32 reads %d, expect %d", value, i)
+		}
+	}
+	for _, f := range append([]float64{1.5, -math.MaxFloat64}, testFloat64s...) {
+		wf, err := heap.allocator.Float64(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapped, err := heap.FetchWrapped(wf.Mono().beginFrom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched, ok := wrapped.(*WrappedFloat64)
+		if !ok {
+			t.Fatalf("Float64 mono is fetched as %T, expect %T", wrapped, wf)
+		}
+		value, err := fetched.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Float64bits(value) != math.Float64bits(f) {
+			t.Errorf("Float64 reads %v (%x), expect %v (%x)", value, math.Float64bits(value), f, math.Float64bits(f))
+		}
+	}
+
+	null, err := heap.allocator.Null()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := heap.FetchWrapped(null.beginFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mono, ok := wrapped.(*Mono); !ok || mono.kind != MONO_NULL {
+		t.Errorf("Null is fetched as %T, expect the null mono", wrapped)
+	}
+}
+
+func TestNumbersByteOrder(t *testing.T) {
+	heap := NewHeapWithConfig(HeapConfig{RegionSize: 4096, NumberRegions: 4})
+	content := heap.content[0]
+	bigEndian := heap.RegionFromContentWithOrder(0, 4096, content, binary.BigEndian)
+	mono, err := bigEndian.CreateMono(MONO_INT32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWrappedInt32(mono).WriteValue(0x01020304); err != nil {
+		t.Fatal(err)
+	}
+	raw := content[mono.valueFromOffset:mono.endOffset]
+	if !bytes.Equal(raw, []byte{1, 2, 3, 4}) {
+		t.Errorf("Big-endian int32 bytes are %#v, expect %#v", raw, []byte{1, 2, 3, 4})
+	}
+	i, err := NewWrappedInt32(mono).ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 0x01020304 {
+		t.Errorf("Big-endian int32 reads %#x, expect %#x", i, 0x01020304)
+	}
+
+	// The same bytes in a little-endian region are reversed.
+	littleEndian := heap.RegionFromContent(0, 4096, content)
+	reversed, err := littleEndian.NewMono(MONO_INT32, mono.beginOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err = NewWrappedInt32(reversed).ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 0x04030201 {
+		t.Errorf("Little-endian int32 reads %#x, expect %#x", i, 0x04030201)
+	}
+
+	mono, err = bigEndian.CreateMono(MONO_FLOAT64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWrappedFloat64(mono).WriteValue(1.5); err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, 8)
+	binary.BigEndian.PutUint64(expected, math.Float64bits(1.5))
+	if raw := content[mono.valueFromOffset:mono.endOffset]; !bytes.Equal(raw, expected) {
+		t.Errorf("Big-endian float64 bytes are %#v, expect %#v", raw, expected)
+	}
+	f, err := NewWrappedFloat64(mono).ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != 1.5 {
+		t.Errorf("Big-endian float64 reads %v, expect %v", f, 1.5)
+	}
+}
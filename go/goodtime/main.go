@@ -1,21 +1,100 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"os"
 
+	"github.com/pptang/goodtime/go/goodtime/heap"
 	"github.com/pptang/goodtime/go/goodtime/otto"
+	"github.com/pptang/goodtime/go/goodtime/otto/ast"
 	"github.com/pptang/goodtime/go/goodtime/otto/parser"
 )
 
+var heapRegions = flag.Int("heap-regions", 0, "how many heap regions are pre-allocated (0: heap.NUMBER_REGIONS)")
+var regionSize = flag.Uint("region-size", 0, "how many bytes each heap region is (0: heap.REGION_SIZE)")
+var replMode = flag.Bool("repl", false, "read and run lines from stdin, instead of a file")
+
 func main() {
 	flag.Parse()
+	cfg, err := heapConfig(*heapRegions, *regionSize)
+	if err != nil {
+		exit(err)
+	}
+	if *replMode {
+		if err := repl(os.Stdin, os.Stdout, cfg); err != nil {
+			exit(err)
+		}
+		return
+	}
+
 	filename := flag.Arg(0)
-	ast, err := parser.ParseFile(nil, filename, nil, 0)
+	program, err := parser.ParseFile(nil, filename, nil, 0)
 	if err != nil {
-		fmt.Println(err)
-		panic("Failed to parse file")
+		exit(fmt.Errorf("Failed to parse file %s:\n%v", filename, err))
+	}
+	if err := run(program, cfg); err != nil {
+		exit(fmt.Errorf("Failed to run file %s:\n%v", filename, err))
+	}
+}
+
+// The heap config of the flags. Region sizes are offsets in regions, which are uint32,
+// so a larger one is an error, instead of wrapping around to another size. So is a heap
+// larger than pointers can address, which NewHeapWithConfig would panic for.
+func heapConfig(regions int, regionSize uint) (heap.HeapConfig, error) {
+	if regionSize > math.MaxUint32 {
+		return heap.HeapConfig{}, fmt.Errorf("Invalid -region-size %d: it must be at most %d", regionSize, uint64(math.MaxUint32))
+	}
+	cfg := heap.HeapConfig{
+		RegionSize:    uint32(regionSize),
+		NumberRegions: regions,
+	}
+	if err := cfg.Check(); err != nil {
+		return heap.HeapConfig{}, fmt.Errorf("Invalid -heap-regions and -region-size: %v", err)
+	}
+	return cfg, nil
+}
+
+// Ordinary errors, like syntax errors in the file, are for users,
+// so they are printed without a stack trace.
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// Run the program with values allocated on a heap of the config.
+func run(program *ast.Program, cfg heap.HeapConfig) error {
+	interpreter := otto.NewWithHeap(heap.NewHeapWithConfig(cfg))
+	_, err := interpreter.Run(program)
+	return err
+}
+
+// Print this line in the REPL to see heap.Stats.
+const replStatsCommand = ".stats"
+
+// Run each line read from `in` until EOF, on one interpreter, so values
+// of previous lines are still on the heap. Results and errors of lines are
+// printed to `out`, and an error in one line doesn't stop the REPL.
+func repl(in io.Reader, out io.Writer, cfg heap.HeapConfig) error {
+	interpreter := otto.NewWithHeap(heap.NewHeapWithConfig(cfg))
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(out, "> ")
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == replStatsCommand {
+			fmt.Fprintf(out, "%+v\n", interpreter.Heap().Stats())
+		} else if program, err := parser.ParseFile(nil, "", line, 0); err != nil {
+			fmt.Fprintln(out, err)
+		} else if value, err := interpreter.Run(program); err != nil {
+			fmt.Fprintln(out, err)
+		} else {
+			fmt.Fprintln(out, value)
+		}
+		fmt.Fprint(out, "> ")
 	}
-	interpreter := otto.New()
-	interpreter.Run(ast)
+	fmt.Fprintln(out)
+	return scanner.Err()
 }
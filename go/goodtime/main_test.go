@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pptang/goodtime/go/goodtime/heap"
+	"github.com/pptang/goodtime/go/goodtime/otto/parser"
+)
+
+func TestRunWithHeapConfig(t *testing.T) {
+	tiny := heap.HeapConfig{RegionSize: 4096, NumberRegions: 1}
+
+	small, err := parser.ParseFile(nil, "", `var abc = [1, 2, 3];`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(small, tiny); err != nil {
+		t.Errorf("Run a small script on a tiny heap: %v", err)
+	}
+
+	big, err := parser.ParseFile(nil, "", `
+        var abc = [];
+        for (var i = 0; i < 1000; i++) {
+            abc.push([i, i + 1]);
+        }
+    `, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run(big, tiny); err == nil || !strings.Contains(err.Error(), heap.ErrorMessageHeapFull) {
+		t.Errorf("Running a big script on a tiny heap fails with %v, expect %q", err, heap.ErrorMessageHeapFull)
+	}
+}
+
+func TestHeapConfigFlags(t *testing.T) {
+	cfg, err := heapConfig(4, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NumberRegions != 4 || cfg.RegionSize != 4096 {
+		t.Errorf("Config is %+v, expect 4 regions of 4096 bytes", cfg)
+	}
+	// A uint over uint32 wraps around to 0 on 32-bit platforms.
+	max := uint(math.MaxUint32)
+	if cfg, err := heapConfig(1, max); err != nil || cfg.RegionSize != math.MaxUint32 {
+		t.Errorf("Config is %+v, %v, expect a region size of %d", cfg, err, uint32(math.MaxUint32))
+	}
+	if cfg, err := heapConfig(2, max); err == nil {
+		t.Errorf("Expect error for a heap over 4GB, got %+v", cfg)
+	}
+	if over := max + 1; over != 0 {
+		if cfg, err := heapConfig(4, over); err == nil {
+			t.Errorf("Expect error for a region size over uint32, got %+v", cfg)
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	in := strings.NewReader(strings.Join([]string{
+		"var abc = [1, 2, 3];",
+		"var def = ;",
+		"abc.length",
+		".stats",
+	}, "\n"))
+	var out bytes.Buffer
+	if err := repl(in, &out, heap.HeapConfig{RegionSize: 4096, NumberRegions: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Output of each line follows its prompt.
+	lines := strings.Split(out.String(), "> ")
+	if len(lines) != 6 {
+		t.Fatalf("REPL prints %d prompts, expect %d: %q", len(lines)-1, 5, out.String())
+	}
+	if !strings.Contains(lines[2], "Unexpected token") {
+		t.Errorf("REPL prints %q for a syntax error, expect the parse error", lines[2])
+	}
+	if lines[3] != "3\n" {
+		t.Errorf("REPL prints %q, expect %q", lines[3], "3\n")
+	}
+	// The array from the first line is still on the heap.
+	if !strings.Contains(lines[4], "Monos:") || strings.Contains(lines[4], "Monos:0") {
+		t.Errorf("REPL prints %q for stats, expect monos on the heap", lines[4])
+	}
+}
+
+// Run main in a subprocess of the test binary, so its exit can be observed.
+func TestMainSyntaxError(t *testing.T) {
+	if filename := os.Getenv("GOODTIME_TEST_MAIN"); filename != "" {
+		os.Args = []string{"goodtime", filename}
+		main()
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "goodtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "syntax_error.js")
+	if err := ioutil.WriteFile(filename, []byte("var abc = ;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestMainSyntaxError")
+	cmd.Env = append(os.Environ(), "GOODTIME_TEST_MAIN="+filename)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("Expect a non-zero exit, got: %v", err)
+	}
+	if exitErr.ExitCode() != 1 {
+		t.Errorf("Exit code is %d, expect %d", exitErr.ExitCode(), 1)
+	}
+	if !strings.Contains(stderr.String(), filename) {
+		t.Errorf("Error message %q doesn't include the filename", stderr.String())
+	}
+	if strings.Contains(stderr.String(), "panic") || strings.Contains(stderr.String(), "goroutine") {
+		t.Errorf("Error message has a panic stack: %q", stderr.String())
+	}
+}
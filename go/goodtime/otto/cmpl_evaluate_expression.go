@@ -74,6 +74,9 @@ func (self *_runtime) cmpl_evaluate_nodeExpression(node _nodeExpression) Value {
 		return toValue(reference)
 
 	case *_nodeLiteral:
+		if node.value.kind == valueString {
+			self.allocateOnHeap(node.value)
+		}
 		return node.value
 
 	case *_nodeNewExpression:
@@ -114,6 +117,7 @@ func (self *_runtime) cmpl_evaluate_nodeArrayLiteral(node *_nodeArrayLiteral) Va
 	}
 
 	result := self.newArrayOf(valueArray)
+	self.allocateOnHeap(toValue_object(result))
 
 	return toValue_object(result)
 }
@@ -335,6 +339,7 @@ func (self *_runtime) cmpl_evaluate_nodeObjectLiteral(node *_nodeObjectLiteral)
 			panic(fmt.Errorf("Here be dragons: evaluate_nodeObjectLiteral: invalid property.Kind: %v", property.kind))
 		}
 	}
+	self.allocateOnHeap(toValue_object(result))
 
 	return toValue_object(result)
 }
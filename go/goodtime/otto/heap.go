@@ -0,0 +1,226 @@
+package otto
+
+import (
+	"strconv"
+
+	"github.com/pptang/goodtime/go/goodtime/heap"
+)
+
+// Values created by the program, like array, object and string literals, are
+// allocated on the heap of the Otto, by its Allocator. The runtime still evaluates
+// on its own values; the monos are what the heap and its GC work on.
+//
+// A value is put on the heap as it is created, so later changes to it, like
+// `a.push(4)`, are not on the heap yet. Values without a mono kind, like functions,
+// are undefined on the heap.
+//
+// An object keeps the address of its mono. Objects the program can still reach
+// are the roots of the heap (see Otto.HeapRoots), so GC keeps and moves their monos,
+// and the runtime fetches them again by their addresses.
+//
+// An Otto from New or Copy has no heap, so nothing is allocated for it.
+
+// Allocate the value on the heap. Throw a RangeError if the heap cannot allocate it,
+// like when it is full.
+func (self *_runtime) allocateOnHeap(value Value) {
+	if self.otto == nil || self.otto.heap == nil {
+		return
+	}
+	if _, err := self.otto.toHeap(value); err != nil {
+		panic(self.panicRangeError(err.Error()))
+	}
+}
+
+func (self *Otto) toHeap(value Value) (*heap.Mono, error) {
+	allocator := self.heap.Allocator()
+	switch value.kind {
+	case valueNull:
+		return allocator.Null()
+	case valueBoolean:
+		wb, err := allocator.Bool(value.bool())
+		if err != nil {
+			return nil, err
+		}
+		return wb.Mono(), nil
+	case valueNumber:
+		wf, err := allocator.Float64(value.float64())
+		if err != nil {
+			return nil, err
+		}
+		return wf.Mono(), nil
+	case valueString:
+		// String literals are repeated in loops, so they are interned.
+		addr, err := self.heap.Intern(value.string())
+		if err != nil {
+			return nil, err
+		}
+		return self.heap.FetchMono(addr)
+	case valueObject:
+		return self.objectToHeap(value._object())
+	}
+	return allocator.Undefined()
+}
+
+// An object already on the heap is not allocated again,
+// so arrays and objects containing it point to the same mono.
+func (self *Otto) objectToHeap(object *_object) (*heap.Mono, error) {
+	if mono, err := self.heapMono(object); mono != nil || err != nil {
+		return mono, err
+	}
+	allocator := self.heap.Allocator()
+	switch object.class {
+	case "Array":
+		wa, err := allocator.Array()
+		if err != nil {
+			return nil, err
+		}
+		self.setHeapAddress(object, wa.Mono())
+		length := objectLength(object)
+		for index := uint32(0); index < length; index++ {
+			element, err := self.toHeap(ownValue(object, strconv.FormatUint(uint64(index), 10)))
+			if err != nil {
+				return nil, err
+			}
+			if err := wa.Append(element); err != nil {
+				return nil, err
+			}
+		}
+		return wa.Mono(), nil
+	case "Object":
+		wo, err := allocator.Object()
+		if err != nil {
+			return nil, err
+		}
+		self.setHeapAddress(object, wo.Mono())
+		object.enumerate(false, func(name string) bool {
+			var property *heap.Mono
+			property, err = self.toHeap(ownValue(object, name))
+			if err != nil {
+				return false
+			}
+			err = wo.Set(name, property)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return wo.Mono(), nil
+	}
+	return allocator.Undefined()
+}
+
+// Set the address of the mono to the object, before the values in it are allocated,
+// so an object containing itself is found on the heap.
+func (self *Otto) setHeapAddress(object *_object, mono *heap.Mono) {
+	object.heapAddress = mono.BeginFrom()
+	object.heapEpoch = self.heapEpoch
+}
+
+// A mono of the object on the heap, or nil if it is not allocated there,
+// or collected by a GC since.
+func (self *Otto) heapMono(object *_object) (*heap.Mono, error) {
+	self.syncHeapRoots()
+	if object.heapAddress == 0 || object.heapEpoch != self.heapEpoch {
+		return nil, nil
+	}
+	return self.heap.FetchMono(object.heapAddress)
+}
+
+// Write the addresses the last GC updated back to the objects, which are now
+// from the epoch of the GC. Then the objects are not held by the Otto anymore.
+func (self *Otto) syncHeapRoots() {
+	for index, object := range self.heapRootObjects {
+		object.heapAddress = self.heapRoots[index]
+		object.heapEpoch = self.heapEpoch
+	}
+	self.heapRoots = nil
+	self.heapRootObjects = nil
+}
+
+// Visit objects reachable from the runtime: the global object, and the scopes being
+// run, through properties, prototypes, and the stashes of closures and arguments.
+type _heapTracer struct {
+	objects map[*_object]bool
+	stashes map[_stash]bool
+	visit   func(*_object)
+}
+
+func (self *_heapTracer) runtime(runtime *_runtime) {
+	self.object(runtime.globalObject)
+	self.stash(runtime.globalStash)
+	for scope := runtime.scope; scope != nil; scope = scope.outer {
+		self.stash(scope.lexical)
+		self.stash(scope.variable)
+		self.object(scope.this)
+	}
+}
+
+func (self *_heapTracer) object(object *_object) {
+	if object == nil || self.objects[object] {
+		return
+	}
+	self.objects[object] = true
+	self.visit(object)
+	self.object(object.prototype)
+	for _, property := range object.property {
+		switch value := property.value.(type) {
+		case Value:
+			self.value(value)
+		case _propertyGetSet:
+			self.object(value[0])
+			self.object(value[1])
+		}
+	}
+	switch value := object.value.(type) {
+	case _nodeFunctionObject:
+		self.stash(value.stash)
+	case _bindFunctionObject:
+		self.object(value.target)
+		self.value(value.this)
+		for _, argument := range value.argumentList {
+			self.value(argument)
+		}
+	case _argumentsObject:
+		self.stash(value.stash)
+	}
+}
+
+func (self *_heapTracer) value(value Value) {
+	if value.kind == valueObject {
+		self.object(value._object())
+	}
+}
+
+func (self *_heapTracer) stash(stash _stash) {
+	for ; stash != nil && !self.stashes[stash]; stash = stash.outer() {
+		self.stashes[stash] = true
+		switch stash := stash.(type) {
+		case *_objectStash:
+			self.object(stash.object)
+		case *_dclStash:
+			self.dclStash(stash)
+		case *_fnStash:
+			self.dclStash(&stash._dclStash)
+			self.object(stash.arguments)
+		}
+	}
+}
+
+func (self *_heapTracer) dclStash(stash *_dclStash) {
+	for _, property := range stash.property {
+		self.value(property.value)
+	}
+}
+
+// The value of the own property, without calling its getter.
+// Empty and accessor properties are undefined.
+func ownValue(object *_object, name string) Value {
+	property := object.getOwnProperty(name)
+	if property == nil {
+		return Value{}
+	}
+	if value, ok := property.value.(Value); ok && !value.isEmpty() {
+		return value
+	}
+	return Value{}
+}
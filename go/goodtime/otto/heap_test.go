@@ -0,0 +1,162 @@
+package otto
+
+import (
+	"testing"
+
+	"github.com/pptang/goodtime/go/goodtime/heap"
+)
+
+func TestHeapLiteral(t *testing.T) {
+	tt(t, func() {
+		vm := NewWithHeap(heap.NewHeapWithConfig(heap.HeapConfig{RegionSize: 4096, NumberRegions: 16}))
+
+		_, err := vm.Run(`
+            var abc = [1, 2, 3];
+            var def = { xyzzy: "Nothing happens.", ghi: abc };
+        `)
+		is(err, nil)
+
+		abc, err := vm.Get("abc")
+		is(err, nil)
+		mono, err := vm.heapMono(abc._object())
+		is(err, nil)
+		is(mono != nil, true)
+		is(mono.Kind(), heap.MONO_ARRAY_S8)
+		wa, err := heap.NewWrappedArray(mono)
+		is(err, nil)
+		length, err := wa.ReadLength()
+		is(err, nil)
+		is(length, 3)
+
+		def, err := vm.Get("def")
+		is(err, nil)
+		mono, err = vm.heapMono(def._object())
+		is(err, nil)
+		is(mono != nil, true)
+		is(mono.Kind(), heap.MONO_OBJECT_S8)
+		wo, err := heap.NewWrappedObject(mono)
+		is(err, nil)
+		keys, err := wo.Keys()
+		is(err, nil)
+		is(len(keys), 2)
+
+		ghi, err := wo.Get("ghi")
+		is(err, nil)
+		is(ghi.Kind(), heap.MONO_ARRAY_S8)
+		wa, err = heap.NewWrappedArray(ghi)
+		is(err, nil)
+		length, err = wa.ReadLength()
+		is(err, nil)
+		is(length, 3)
+	})
+}
+
+func TestHeapGC(t *testing.T) {
+	tt(t, func() {
+		h := heap.NewHeapWithConfig(heap.HeapConfig{RegionSize: 4096, NumberRegions: 16})
+		vm := NewWithHeap(h)
+
+		_, err := vm.Run(`
+            var abc = [1, 2, 3];
+            var garbage = [4, 5, 6];
+        `)
+		is(err, nil)
+		abc, err := vm.Get("abc")
+		is(err, nil)
+		before, err := vm.heapMono(abc._object())
+		is(err, nil)
+		garbage, err := vm.Get("garbage")
+		is(err, nil)
+		_, err = vm.Run(`
+            garbage = null;
+        `)
+		is(err, nil)
+
+		// Only objects the program can reach are the roots.
+		roots := vm.HeapRoots()
+		is(len(roots), 1)
+		is(roots[0], before.BeginFrom())
+
+		// The roots are updated in place, so the runtime finds abc where it is moved to.
+		stats, err := h.MinorGC(roots)
+		is(err, nil)
+		// abc and its numbers are copied, but not garbage and its numbers.
+		is(stats.MonosCopied, 4)
+		is(stats.BytesReclaimed > 0, true)
+		after, err := vm.heapMono(abc._object())
+		is(err, nil)
+		is(after.BeginFrom() != before.BeginFrom(), true)
+		is(after.Kind(), heap.MONO_ARRAY_S8)
+		collected, err := vm.heapMono(garbage._object())
+		is(err, nil)
+		is(collected == nil, true)
+
+		_, err = vm.Run(`
+            var def = [abc];
+        `)
+		is(err, nil)
+		def, err := vm.Get("def")
+		is(err, nil)
+		mono, err := vm.heapMono(def._object())
+		is(err, nil)
+		wa, err := heap.NewWrappedArray(mono)
+		is(err, nil)
+		element, err := wa.Index(0)
+		is(err, nil)
+		is(element.BeginFrom(), after.BeginFrom())
+		is(h.Validate(), nil)
+	})
+}
+
+func TestHeapRootsClosure(t *testing.T) {
+	tt(t, func() {
+		vm := NewWithHeap(heap.NewHeapWithConfig(heap.HeapConfig{RegionSize: 4096, NumberRegions: 16}))
+
+		_, err := vm.Run(`
+            var get = (function() {
+                var hidden = [7];
+                return function() { return hidden; };
+            })();
+        `)
+		is(err, nil)
+		hidden, err := vm.Run(`get()`)
+		is(err, nil)
+		mono, err := vm.heapMono(hidden._object())
+		is(err, nil)
+
+		// Only the closure reaches hidden.
+		roots := vm.HeapRoots()
+		is(len(roots), 1)
+		is(roots[0], mono.BeginFrom())
+	})
+}
+
+func TestHeapNone(t *testing.T) {
+	tt(t, func() {
+		vm := New()
+		is(vm.Heap() == nil, true)
+
+		_, err := vm.Run(`
+            var abc = [1, 2, 3];
+        `)
+		is(err, nil)
+		abc, err := vm.Get("abc")
+		is(err, nil)
+		is(abc.String(), "1,2,3")
+	})
+}
+
+func TestHeapFull(t *testing.T) {
+	tt(t, func() {
+		vm := NewWithHeap(heap.NewHeapWithConfig(heap.HeapConfig{RegionSize: 4096, NumberRegions: 1}))
+
+		_, err := vm.Run(`
+            var abc = [];
+            for (var i = 0; i < 1000; i++) {
+                abc.push([i]);
+            }
+        `)
+		is(err != nil, true)
+		is(err.Error(), "RangeError: "+heap.ErrorMessageHeapFull)
+	})
+}
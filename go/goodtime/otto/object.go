@@ -12,6 +12,11 @@ type _object struct {
 
 	property      map[string]_property
 	propertyOrder []string
+
+	// Address of the mono of the object, if it is on the heap of the Otto, and the
+	// GC epoch of the Otto the address is for. See Otto.HeapRoots.
+	heapAddress uint64
+	heapEpoch   uint64
 }
 
 func newObject(runtime *_runtime, class string) *_object {
@@ -240,13 +240,20 @@ type Otto struct {
 	Interrupt chan func()
 	runtime   *_runtime
 	heap      *heap.Heap
+
+	// Each GC of the heap starts a new epoch. Addresses of objects from an older
+	// epoch are stale. heapRoots are the addresses the last GC started from, which
+	// it updates in place, and heapRootObjects the objects they are written back to.
+	heapEpoch       uint64
+	heapRoots       []uint64
+	heapRootObjects []*_object
 }
 
-// New will allocate a new JavaScript runtime
+// New will allocate a new JavaScript runtime, without a heap.
+// Use NewWithHeap to allocate values created by the program on a heap.
 func New() *Otto {
 	self := &Otto{
 		runtime: newContext(),
-		heap:    heap.NewHeap(),
 	}
 	self.runtime.otto = self
 	self.runtime.traceLimit = 10
@@ -259,6 +266,48 @@ func New() *Otto {
 	return self
 }
 
+// NewWithHeap will allocate a new JavaScript runtime, which allocates
+// values created by the program on the given heap. The runtime registers
+// its roots on the heap by SetRoots; see HeapRoots.
+func NewWithHeap(h *heap.Heap) *Otto {
+	self := New()
+	self.heap = h
+	h.SetRoots(self.HeapRoots)
+	return self
+}
+
+// Heap returns the heap which values created by the program are allocated on,
+// or nil if the runtime is from New.
+func (self *Otto) Heap() *heap.Heap {
+	return self.heap
+}
+
+// HeapRoots returns the addresses of the monos of objects on the heap the program
+// can still reach, from the global object and the scopes being run. A host running
+// GC by itself, like by Heap.MinorGC, must pass this slice to the GC before running
+// the program again. The GC updates it in place, so the runtime finds the monos after
+// they move. Other objects are taken as collected, and allocated again if they are used.
+func (self *Otto) HeapRoots() []uint64 {
+	if self.heap == nil {
+		return nil
+	}
+	self.syncHeapRoots()
+	epoch := self.heapEpoch
+	self.heapEpoch++
+	tracer := _heapTracer{
+		objects: map[*_object]bool{},
+		stashes: map[_stash]bool{},
+		visit: func(object *_object) {
+			if object.heapAddress != 0 && object.heapEpoch == epoch {
+				self.heapRoots = append(self.heapRoots, object.heapAddress)
+				self.heapRootObjects = append(self.heapRootObjects, object)
+			}
+		},
+	}
+	tracer.runtime(self.runtime)
+	return self.heapRoots
+}
+
 func (otto *Otto) clone() *Otto {
 	self := &Otto{
 		runtime: otto.runtime.clone(),